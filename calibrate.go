@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Calibration is the zero reference of a tracker's rotation. Forwarded
+// rotations are relative to it, the stored tracker keeps the raw values so a
+// new calibration captures those.
+type Calibration struct {
+	Source     string      `json:"source,omitempty"`
+	ID         int         `json:"id"`
+	Rotation   *[3]float32 `json:"rotation,omitempty"`   // Euler reference, nil when the tracker sent none
+	Quaternion *[4]float32 `json:"quaternion,omitempty"` // quaternion reference, same
+}
+
+// apply makes the rotations data carries relative to the reference. Euler
+// angles are offset per axis and wrapped back into (-180,180], quaternions
+// are rotated by the inverse of the reference.
+func (c Calibration) apply(data *TrackerData) {
+	if c.Rotation != nil && data.Fields&FieldRotation != 0 {
+		for i := range data.Rotation {
+			data.Rotation[i] = float32(angleDelta(c.Rotation[i], data.Rotation[i]))
+		}
+	}
+	if c.Quaternion != nil && data.Fields&FieldQuaternion != 0 {
+		data.Quaternion = quaternionMul(quaternionInverse(*c.Quaternion), data.Quaternion)
+	}
+}
+
+// quaternionInverse returns the inverse of q (x,y,z,w), q itself when it has
+// no length.
+func quaternionInverse(q [4]float32) [4]float32 {
+	n := float64(q[0])*float64(q[0]) + float64(q[1])*float64(q[1]) + float64(q[2])*float64(q[2]) + float64(q[3])*float64(q[3])
+	if n == 0 || math.IsNaN(n) {
+		return q
+	}
+	return [4]float32{float32(-float64(q[0]) / n), float32(-float64(q[1]) / n), float32(-float64(q[2]) / n), float32(float64(q[3]) / n)}
+}
+
+// quaternionMul returns the Hamilton product a*b of quaternions (x,y,z,w).
+func quaternionMul(a, b [4]float32) [4]float32 {
+	return [4]float32{
+		a[3]*b[0] + a[0]*b[3] + a[1]*b[2] - a[2]*b[1],
+		a[3]*b[1] - a[0]*b[2] + a[1]*b[3] + a[2]*b[0],
+		a[3]*b[2] + a[0]*b[1] - a[1]*b[0] + a[2]*b[3],
+		a[3]*b[3] - a[0]*b[0] - a[1]*b[1] - a[2]*b[2],
+	}
+}
+
+// Errors of Calibrate
+var (
+	errTrackerNotFound = errors.New("no such tracker")
+	errNoRotation      = errors.New("tracker has not sent a rotation yet")
+)
+
+// fileStore is the JSON file calibrations or the disabled trackers are saved
+// to, an empty path keeps them in memory only.
+type fileStore struct {
+	mu   sync.Mutex // serializes writes
+	path string
+}
+
+// load points the store at path and decodes the file into v. A missing file
+// leaves v alone.
+func (s *fileStore) load(path string, v any) error {
+	s.mu.Lock()
+	s.path = path
+	s.mu.Unlock()
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return nil
+}
+
+// save writes what current returns to the file, through a temporary file so
+// a crash cannot leave it half written. current is called with the store
+// locked so concurrent saves cannot write an older state last.
+func (s *fileStore) save(what string, current func() any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.path == "" {
+		return
+	}
+
+	data, err := json.MarshalIndent(current(), "", "  ")
+	if err == nil {
+		err = os.WriteFile(s.path+".tmp", append(data, '\n'), 0o644)
+	}
+	if err == nil {
+		err = os.Rename(s.path+".tmp", s.path)
+	}
+	if err != nil {
+		slog.Error("Saving "+what+" failed", "path", s.path, "err", err)
+	}
+}
+
+// Calibrate captures the current rotation of the tracker as its zero
+// reference, replacing an earlier one.
+func (tm *TrackerManager) Calibrate(source string, id int) (Calibration, error) {
+	key := trackerKey{source: source, id: id}
+	tm.mu.Lock()
+	tracker, exists := tm.trackers[key]
+	if !exists {
+		tm.mu.Unlock()
+		return Calibration{}, errTrackerNotFound
+	}
+	cal := Calibration{Source: source, ID: id}
+	if tracker.Fields&FieldRotation != 0 {
+		rotation := tracker.Rotation
+		cal.Rotation = &rotation
+	}
+	if tracker.Fields&FieldQuaternion != 0 {
+		quaternion := tracker.Quaternion
+		cal.Quaternion = &quaternion
+	}
+	if cal.Rotation == nil && cal.Quaternion == nil {
+		tm.mu.Unlock()
+		return Calibration{}, errNoRotation
+	}
+	tm.calibrations[key] = cal
+	tm.mu.Unlock()
+
+	slog.Info("Tracker calibrated", "tracker", id, "source", source)
+	tm.saveCalibrations()
+	return cal, nil
+}
+
+// ClearCalibration drops the reference of the tracker, it reports whether
+// there was one.
+func (tm *TrackerManager) ClearCalibration(source string, id int) bool {
+	key := trackerKey{source: source, id: id}
+	tm.mu.Lock()
+	_, exists := tm.calibrations[key]
+	delete(tm.calibrations, key)
+	tm.mu.Unlock()
+
+	if exists {
+		slog.Info("Tracker calibration cleared", "tracker", id, "source", source)
+		tm.saveCalibrations()
+	}
+	return exists
+}
+
+// Calibrations returns all references, sorted like GetAllTrackers.
+func (tm *TrackerManager) Calibrations() []Calibration {
+	tm.mu.RLock()
+	out := make([]Calibration, 0, len(tm.calibrations))
+	for _, cal := range tm.calibrations {
+		out = append(out, cal)
+	}
+	tm.mu.RUnlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Source != out[j].Source {
+			return out[i].Source < out[j].Source
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+// LoadCalibrations reads the references saved in path and keeps saving
+// changes there. A missing file starts out empty.
+func (tm *TrackerManager) LoadCalibrations(path string) error {
+	var cals []Calibration
+	if err := tm.calibrationStore.load(path, &cals); err != nil {
+		return err
+	}
+
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	for _, cal := range cals {
+		tm.calibrations[trackerKey{source: cal.Source, id: cal.ID}] = cal
+	}
+	return nil
+}
+
+// saveCalibrations writes all references to the calibration file.
+func (tm *TrackerManager) saveCalibrations() {
+	tm.calibrationStore.save("calibrations", func() any { return tm.Calibrations() })
+}
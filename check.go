@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"strings"
+)
+
+// checkConfig reports whether the config at path loads and validates,
+// writing either a summary of what it sets up or every problem found to w.
+// load is the same loader main uses, so flag overrides are checked as well.
+func checkConfig(w io.Writer, path string, load func() (*Config, error)) bool {
+	if _, err := os.Stat(path); err != nil {
+		fmt.Fprintf(w, "%s: %v\n", path, err)
+		return false
+	}
+	cfg, err := load()
+	if err != nil {
+		fmt.Fprintf(w, "%s has errors:\n", path)
+		var joined interface{ Unwrap() []error }
+		if errors.As(err, &joined) {
+			for _, err := range joined.Unwrap() {
+				fmt.Fprintf(w, "  - %v\n", err)
+			}
+		} else {
+			fmt.Fprintf(w, "  - %v\n", err)
+		}
+		return false
+	}
+
+	fmt.Fprintf(w, "OK %s\n", path)
+	fmt.Fprintf(w, "  listen:       %s (%s)\n", strings.Join(cfg.AllListenAddrs(), ", "), transportName(cfg.ListenTransport))
+	endpoints := 0
+	for _, dest := range cfg.AllDestinations() {
+		for _, ep := range dest.endpoints() {
+			endpoints++
+			fmt.Fprintf(w, "  destination:  %s (%s) %s", ep, transportName(ep.Transport), fieldNames(ep.fields))
+			if len(ep.IDs) > 0 {
+				fmt.Fprintf(w, " ids %v", ep.IDs)
+			}
+			if ep.Address != "" {
+				fmt.Fprintf(w, " address %s", ep.Address)
+			}
+			fmt.Fprintln(w)
+		}
+	}
+	if endpoints == 0 {
+		fmt.Fprintln(w, "  destination:  none, updates are tracked but not forwarded")
+	}
+	if cfg.Schema.IDArg >= 0 {
+		fmt.Fprintf(w, "  schema:       %s..., ID in argument %d\n", cfg.Schema.prefix(), cfg.Schema.IDArg)
+	} else {
+		fmt.Fprintf(w, "  schema:       %s..., ID in segment %d\n", cfg.Schema.prefix(), cfg.Schema.IDIndex)
+	}
+	if cfg.DebugAddr != "" {
+		fmt.Fprintf(w, "  debug server: %s\n", cfg.DebugAddr)
+	}
+	return true
+}
+
+// printConfig writes cfg as YAML, every top-level setting commented with
+// where its value comes from: the default, the file at path, or the flag
+// overrides names for it. Settings keep the order of Config, so the output
+// of two runs diffs cleanly.
+func printConfig(w io.Writer, path string, cfg *Config, overrides map[string]string) error {
+	fromFile := make(map[string]yaml.Node)
+	data, err := os.ReadFile(path)
+	if err == nil {
+		err = yaml.Unmarshal(data, &fromFile)
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	var doc yaml.Node
+	if err := doc.Encode(cfg); err != nil {
+		return err
+	}
+	for i := 0; i+1 < len(doc.Content); i += 2 {
+		key, value := doc.Content[i], doc.Content[i+1]
+		comment := "default"
+		if overrides[key.Value] != "" {
+			comment = "from " + overrides[key.Value]
+		} else if _, inFile := fromFile[key.Value]; inFile {
+			comment = "from " + path
+		}
+		// yaml drops the comment of a key whose value is an empty [] or {}
+		if len(value.Content) == 0 {
+			value.LineComment = comment
+		} else {
+			key.LineComment = comment
+		}
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+func transportName(transport string) string {
+	if transport == "" {
+		return TransportUDP
+	}
+	return transport
+}
+
+// fieldNames lists the fields in mask for display.
+func fieldNames(mask uint8) string {
+	names := updateFieldNames(mask)
+	if mask&fieldRaw != 0 {
+		names = append(names, "passthrough")
+	}
+	return "[" + strings.Join(names, " ") + "]"
+}
+
+// updateFieldNames lists the fields an update carries.
+func updateFieldNames(fields uint8) []string {
+	names := []string{}
+	for _, f := range []struct {
+		field uint8
+		name  string
+	}{
+		{FieldPosition, "position"},
+		{FieldRotation, "rotation"},
+		{FieldQuaternion, "quaternion"},
+		{FieldVelocity, "velocity"},
+		{FieldActive, "active"},
+	} {
+		if fields&f.field != 0 {
+			names = append(names, f.name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,871 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Config holds the runtime settings. It is read from a YAML file; since YAML
+// is a superset of JSON, a JSON file works as well.
+type Config struct {
+	ListenAddr        string            `yaml:"listen_addr"`         // this applications OSC listener
+	ListenAddrs       []string          `yaml:"listen_addrs"`        // additional listen addresses, see AllListenAddrs
+	ListenTransport   string            `yaml:"listen_transport"`    // udp, tcp or unix, for unix the listen addresses are socket paths
+	ListenFamily      string            `yaml:"listen_family"`       // IP family of wildcard listen addresses, see Family*
+	ListenerWatchdog  time.Duration     `yaml:"listener_watchdog"`   // warn when nothing arrived for this long after traffic was seen, 0 disables
+	ListenerRestart   bool              `yaml:"listener_restart"`    // also close and reopen the listeners when the watchdog fires
+	DestHost          string            `yaml:"dest_host"`           // destination OSC server address
+	DestPort          int               `yaml:"dest_port"`           // destination OSC server port
+	DestTransport     string            `yaml:"dest_transport"`      // udp, tcp or unix
+	DestPath          string            `yaml:"dest_path"`           // socket path for the unix transport, replaces dest_host and dest_port
+	DestRoutes        map[string]Route  `yaml:"dest_routes"`         // per field overrides of dest_host/dest_port, see Destination
+	DestNoDelay       *bool             `yaml:"dest_no_delay"`       // see Destination
+	DestWriteBuffer   int               `yaml:"dest_write_buffer"`   // see Destination
+	DestIDs           []int             `yaml:"dest_ids"`            // see Destination
+	DestFields        []string          `yaml:"dest_fields"`         // see Destination
+	DestEncoding      map[string]string `yaml:"dest_encoding"`       // see Destination
+	DestAddress       string            `yaml:"dest_address"`        // see Destination
+	Destinations      []Destination     `yaml:"destinations"`        // additional destinations, see AllDestinations
+	UpdateBufferSize  int               `yaml:"update_buffer_size"`  // capacity of the incoming update channel
+	ForwardBufferSize int               `yaml:"forward_buffer_size"` // capacity of the outgoing forward channel
+	OverflowPolicy    string            `yaml:"overflow_policy"`     // what to drop when a channel is full, see Overflow*
+	QueueHighWater    float64           `yaml:"queue_high_water"`    // fill ratio at which a queue counts as saturated
+	DrainTimeout      time.Duration     `yaml:"drain_timeout"`       // how long shutdown keeps working through the queues before dropping what is left
+
+	TrackerTTL    time.Duration `yaml:"tracker_ttl"`    // trackers not seen for this long are removed, 0 disables
+	SweepInterval time.Duration `yaml:"sweep_interval"` // how often to check for expired trackers
+	MaxTrackers   int           `yaml:"max_trackers"`   // most trackers kept at once, 0 is unlimited
+	TrackerLimit  string        `yaml:"tracker_limit"`  // what to do with a new tracker beyond max_trackers, see Limit*
+
+	DebugAddr      string `yaml:"debug_addr"`      // debug HTTP server address, empty disables it
+	DebugWebSocket bool   `yaml:"debug_websocket"` // stream updates as JSON on GET /ws of the debug server
+	StreamAddr     string `yaml:"stream_addr"`     // TCP address streaming updates as newline delimited JSON, empty disables it
+	LogLevel       string `yaml:"log_level"`       // debug, info, warn or error
+	LogFormat      string `yaml:"log_format"`      // text or json
+
+	LogLatency  bool          `yaml:"log_latency"`  // log the latency of every forwarded message at debug level
+	LogThrottle time.Duration `yaml:"log_throttle"` // repeats of the same error within this window are logged as one line with a count, 0 logs each
+
+	Schema     Schema `yaml:"schema"`      // layout of incoming tracker addresses
+	TrackerIDs []int  `yaml:"tracker_ids"` // only accept these incoming tracker IDs, empty accepts all
+
+	QueryAddress string `yaml:"query_address"` // a message to this address is answered with the tracker list, empty disables
+
+	CalibrationFile string `yaml:"calibration_file"` // rotation references are saved here and loaded on start, empty keeps them in memory
+	DisabledFile    string `yaml:"disabled_file"`    // trackers switched off at runtime are saved here and loaded on start, empty keeps them in memory
+
+	NonFinite          string          `yaml:"non_finite"`           // what to do with NaN/Inf values, see NonFinite*
+	InversionThreshold float64         `yaml:"inversion_threshold"`  // degrees an axis must jump to count as inverted, <= 0 disables
+	InversionSamples   int             `yaml:"inversion_samples"`    // consecutive inverted samples needed before correcting
+	InversionIDs       []int           `yaml:"inversion_ids"`        // only correct these trackers, empty means all
+	InversionSkipIDs   []int           `yaml:"inversion_skip_ids"`   // never correct these trackers
+	SmoothingFactor    float64         `yaml:"smoothing_factor"`     // weight of the previous value in the moving average, 0 disables
+	SmoothingFactors   map[int]float64 `yaml:"smoothing_factors"`    // tracker ID -> smoothing_factor for that tracker, unlisted IDs use smoothing_factor
+	SmoothingMode      string          `yaml:"smoothing_mode"`       // smoothing algorithm, see Smoothing*
+	SmoothingHalfLife  time.Duration   `yaml:"smoothing_half_life"`  // with the spring, about the time to cover half the way to a new value
+	SmoothingMinCutoff float64         `yaml:"smoothing_min_cutoff"` // with one-euro, the cutoff in Hz at rest, lower smooths more
+	SmoothingBeta      float64         `yaml:"smoothing_beta"`       // with one-euro, Hz added to the cutoff per unit per second of speed, higher lags less
+	SmoothingDCutoff   float64         `yaml:"smoothing_d_cutoff"`   // with one-euro, the cutoff in Hz the speed is smoothed at
+	PositionDeadband   float64         `yaml:"position_deadband"`    // position changes smaller than this on every axis are dropped, 0 disables
+	RotationDeadband   float64         `yaml:"rotation_deadband"`    // same for rotation, in degrees
+
+	Velocity            bool          `yaml:"velocity"`              // derive and forward velocity from position changes
+	VelocityMinInterval time.Duration `yaml:"velocity_min_interval"` // minimum time between the samples a velocity is computed from
+
+	JitterWindow time.Duration `yaml:"jitter_window"` // hold updates with a source time this long to put them back in order, 0 disables
+
+	HistorySize int `yaml:"history_size"` // updates kept per tracker for GET /trackers/{id}/history, 0 disables
+
+	FreezeAfter   time.Duration `yaml:"freeze_after"`   // warn when a tracker keeps sending unchanged values this long, 0 disables
+	FreezeEpsilon float64       `yaml:"freeze_epsilon"` // changes up to this count as unchanged
+
+	SkipInactive bool `yaml:"skip_inactive"` // do not forward trackers whose last status message said inactive
+
+	PredictLead     time.Duration `yaml:"predict_lead"`      // forward positions projected this far ahead along the velocity, 0 disables
+	PredictMaxSpeed float64       `yaml:"predict_max_speed"` // velocity magnitude used for prediction is clamped to this, 0 is unlimited
+	PredictMaxAge   time.Duration `yaml:"predict_max_age"`   // updates, and velocities, older than this when forwarded are not projected
+
+	PositionAxes []string `yaml:"position_axes"` // remap of the position axes, e.g. ["+x","+z","-y"], empty is identity
+	RotationAxes []string `yaml:"rotation_axes"` // same for the Euler rotation axes
+	FramePreset  string   `yaml:"frame_preset"`  // convert from input_frame to this coordinate frame (unity, unreal, opengl, blender) instead of the remaps above, empty disables
+	InputFrame   string   `yaml:"input_frame"`   // coordinate frame the senders use, see FramePreset
+	Mirror       string   `yaml:"mirror"`        // reflect the tracking space along this axis (x, y or z) after the transforms, empty disables
+
+	PositionMatrix [][]float64 `yaml:"position_matrix"` // 3x3 or 4x4 homogeneous transform applied after the axis remap, empty is identity
+	PositionScale  []float64   `yaml:"position_scale"`  // per axis factor applied after the matrix, empty is 1
+	PositionOffset []float64   `yaml:"position_offset"` // per axis offset added after scaling, empty is 0
+
+	PositionMin []float64 `yaml:"position_min"`  // per axis lower bound of the transformed position, empty is unbounded
+	PositionMax []float64 `yaml:"position_max"`  // same for the upper bound
+	OutOfBounds string    `yaml:"out_of_bounds"` // what to do with positions outside the bounds, see OutOfBounds*
+
+	ForwardEpsilon float64 `yaml:"forward_epsilon"` // a field is only forwarded when a component changed by more than this
+	ForwardAlways  bool    `yaml:"forward_always"`  // forward every update even if unchanged
+
+	PositionStep float64 `yaml:"position_step"` // forwarded positions are rounded to a multiple of this, 0 disables
+	RotationStep float64 `yaml:"rotation_step"` // same for Euler rotations, in degrees
+
+	BundleWindow  time.Duration `yaml:"bundle_window"`   // collect forwarded messages into one OSC bundle per window, 0 disables
+	BundleMaxSize int           `yaml:"bundle_max_size"` // bytes, bigger bundles are split, the default is the most a UDP datagram holds
+	TimetagMode   string        `yaml:"timetag_mode"`    // timetag of outgoing bundles, see Timetag*
+	MaxRate       float64       `yaml:"max_rate"`        // forward each tracker at most this often (Hz), 0 is unthrottled
+
+	FrameRate   float64 `yaml:"frame_rate"`   // forward the latest state of every tracker this often (Hz) instead of per update, 0 disables
+	FrameBundle bool    `yaml:"frame_bundle"` // send each frame as one bundle
+
+	SendAttempts    int           `yaml:"send_attempts"`     // tries per packet and destination, 1 disables retries
+	SendRetryDelay  time.Duration `yaml:"send_retry_delay"`  // wait before the first retry, doubled after each one
+	SendRetryBudget time.Duration `yaml:"send_retry_budget"` // total time a packet may spend in retries per destination
+
+	HeartbeatInterval time.Duration `yaml:"heartbeat_interval"` // send a keepalive to every destination this often, 0 disables
+	HeartbeatAddress  string        `yaml:"heartbeat_address"`  // OSC address of the keepalive
+	HeartbeatPayload  string        `yaml:"heartbeat_payload"`  // argument of the keepalive, see Heartbeat*
+
+	BreakerFailures      int           `yaml:"breaker_failures"`       // consecutive failed sends that mark a destination down, 0 disables
+	BreakerProbeInterval time.Duration `yaml:"breaker_probe_interval"` // how often a down destination is tried again
+
+	SourceNamespace bool              `yaml:"source_namespace"` // keep trackers from different senders apart
+	SourceNames     map[string]string `yaml:"source_names"`     // sender host -> namespace, other senders use their host
+
+	IDMap       map[int]int `yaml:"id_map"`        // tracker ID -> ID used when forwarding
+	IDMapStrict bool        `yaml:"id_map_strict"` // only forward trackers listed in id_map
+}
+
+// Destination is an OSC server that receives forwarded tracker data.
+type Destination struct {
+	Host      string           `yaml:"host"`
+	Port      int              `yaml:"port"`
+	Transport string           `yaml:"transport"` // udp (default), tcp or unix
+	Path      string           `yaml:"path"`      // socket path for unix, which ignores host and port
+	Routes    map[string]Route `yaml:"routes"`    // position, rotation or velocity -> where to send that field instead
+
+	NoDelay     *bool `yaml:"no_delay"`     // TCP_NODELAY for tcp, unset keeps the Go default (on)
+	WriteBuffer int   `yaml:"write_buffer"` // socket send buffer in bytes, 0 keeps the OS default
+
+	IDs    []int    `yaml:"ids"`    // only send these (forwarded) tracker IDs here, empty sends all
+	Fields []string `yaml:"fields"` // only send these fields (position, rotation, velocity), empty sends all
+
+	Encoding map[string]string `yaml:"encoding"` // position, rotation or velocity -> argument type, see Encoding*
+
+	Address string `yaml:"address"` // output address template with {id}, {field} and {source}, empty is defaultAddress
+}
+
+// Argument types of forwarded values
+const (
+	EncodingFloat32 = "float32" // OSC 'f', the default
+	EncodingFloat64 = "float64" // OSC 'd'
+)
+
+// maxWriteBuffer is the largest write_buffer accepted, anything above is
+// almost certainly a typo
+const maxWriteBuffer = 64 << 20
+
+// Route sends one field to a different host and/or port, unset parts are
+// taken from the destination.
+type Route struct {
+	Host string `yaml:"host"`
+	Port int    `yaml:"port"`
+}
+
+// routeFields maps the route names to the fields they carry, quaternions
+// follow rotation.
+var routeFields = map[string]uint8{
+	"position": FieldPosition,
+	"rotation": FieldRotation | FieldQuaternion,
+	"velocity": FieldVelocity,
+}
+
+// fieldRaw marks passthrough messages, which are not routed and always go to
+// the destination itself
+const fieldRaw uint8 = 1 << 7
+
+// endpoint is a destination address together with the fields sent to it.
+type endpoint struct {
+	Destination
+	fields uint8
+}
+
+// doubles returns the fields d sends as float64.
+func (d Destination) doubles() uint8 {
+	var mask uint8
+	for name, encoding := range d.Encoding {
+		if encoding == EncodingFloat64 {
+			mask |= routeFields[name]
+		}
+	}
+	return mask
+}
+
+// endpoints splits d along its routes. The destination itself receives
+// every field that is not routed elsewhere, and passthrough messages. Fields
+// left out of d.Fields go nowhere, routes carrying none of the selected
+// fields are dropped.
+func (d Destination) endpoints() []endpoint {
+	selected := FieldPosition | FieldRotation | FieldQuaternion | FieldVelocity
+	if len(d.Fields) > 0 {
+		selected = 0
+		for _, name := range d.Fields {
+			selected |= routeFields[name]
+		}
+	}
+
+	base := d
+	base.Routes = nil
+	out := []endpoint{{Destination: base, fields: selected | fieldRaw}}
+
+	names := make([]string, 0, len(d.Routes))
+	for name := range d.Routes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		route, ep := d.Routes[name], base
+		if route.Host != "" {
+			ep.Host = route.Host
+		}
+		if route.Port != 0 {
+			ep.Port = route.Port
+		}
+		out[0].fields &^= routeFields[name]
+		if routeFields[name]&selected != 0 {
+			out = append(out, endpoint{Destination: ep, fields: routeFields[name] & selected})
+		}
+	}
+	return out
+}
+
+// maxDatagramSize is the largest UDP payload over IPv4, the default
+// bundle_max_size
+const maxDatagramSize = 65507
+
+// minBundleMaxSize is the smallest bundle_max_size accepted, room for a
+// bundle holding one typical message
+const minBundleMaxSize = 128
+
+// Schema describes the incoming OSC address layout, by default
+// /tracking/trackers/{id}/{position|rotation|pose}.
+type Schema struct {
+	Namespace []string `yaml:"namespace"` // leading address segments
+	IDIndex   int      `yaml:"id_index"`  // index of the ID segment, the empty segment before the first '/' is 0
+	IDArg     int      `yaml:"id_arg"`    // index of the argument holding the ID instead, the values are the other arguments. -1 uses id_index
+	Position  string   `yaml:"position"`  // keyword of position messages
+	Rotation  string   `yaml:"rotation"`  // keyword of rotation messages
+	Pose      string   `yaml:"pose"`      // address segment of combined position+rotation messages, empty disables
+	Active    string   `yaml:"active"`    // address segment of tracker status messages carrying a boolean, empty disables
+
+	PositionOrder []int `yaml:"position_order"` // argument index of x, y and z, empty is [0,1,2]
+	RotationOrder []int `yaml:"rotation_order"` // argument index of pitch, yaw and roll, empty is [0,1,2]
+
+	Handlers []Handler `yaml:"handlers"` // OSC address patterns used instead of the namespace and keywords, empty disables
+}
+
+// Handler routes messages whose address matches an OSC address pattern to
+// one kind of update.
+type Handler struct {
+	Pattern string `yaml:"pattern"` // e.g. /tracking/trackers/[0-9]*/position
+	Kind    string `yaml:"kind"`    // see Kind*
+}
+
+// Kinds of tracker update a message is parsed as
+const (
+	KindPosition = "position" // x,y,z
+	KindRotation = "rotation" // pitch,yaw,roll or a quaternion x,y,z,w
+	KindPose     = "pose"     // x,y,z,pitch,yaw,roll
+	KindActive   = "active"   // a boolean, false while the tracker is not tracking
+)
+
+func (s *Schema) Validate() error {
+	if s.IDArg < -1 || s.IDArg > maxValueArgs {
+		return fmt.Errorf("id_arg %d must be -1 or an argument index from 0 to %d", s.IDArg, maxValueArgs)
+	}
+	if s.IDArg < 0 && s.IDIndex <= len(s.Namespace) {
+		return fmt.Errorf("id_index %d overlaps the namespace %v", s.IDIndex, s.Namespace)
+	}
+	if s.Position == "" || s.Rotation == "" {
+		return fmt.Errorf("position and rotation keywords must be set")
+	}
+	for i, h := range s.Handlers {
+		if err := validatePattern(h.Pattern); err != nil {
+			return fmt.Errorf("handler %d: pattern %q: %w", i, h.Pattern, err)
+		}
+		if h.Kind != KindPosition && h.Kind != KindRotation && h.Kind != KindPose && h.Kind != KindActive {
+			return fmt.Errorf("handler %d: kind %q must be %s, %s, %s or %s", i, h.Kind, KindPosition, KindRotation, KindPose, KindActive)
+		}
+	}
+	if err := validateOrder(s.PositionOrder); err != nil {
+		return fmt.Errorf("position_order: %w", err)
+	}
+	if err := validateOrder(s.RotationOrder); err != nil {
+		return fmt.Errorf("rotation_order: %w", err)
+	}
+	return nil
+}
+
+// validateOrder checks that order is empty or a permutation of 0, 1, 2.
+func validateOrder(order []int) error {
+	if len(order) == 0 {
+		return nil
+	}
+	if len(order) != 3 {
+		return fmt.Errorf("%v needs exactly 3 entries", order)
+	}
+	used := [3]bool{}
+	for _, i := range order {
+		if i < 0 || i > 2 || used[i] {
+			return fmt.Errorf("%v is not a permutation of 0, 1, 2", order)
+		}
+		used[i] = true
+	}
+	return nil
+}
+
+func (d Destination) String() string {
+	if d.Transport == TransportUnix {
+		return d.Path
+	}
+	return net.JoinHostPort(d.Host, fmt.Sprint(d.Port))
+}
+
+func (d Destination) Validate() error {
+	if d.Transport == TransportUnix {
+		if err := validateSocketPath(d.Path); err != nil {
+			return fmt.Errorf("path: %w", err)
+		}
+		if len(d.Routes) > 0 {
+			return fmt.Errorf("routes do not apply to unix, add a destination per path instead")
+		}
+	} else {
+		if d.Path != "" {
+			return fmt.Errorf("path only applies to unix")
+		}
+		if d.Port < 1 || d.Port > 65535 {
+			return fmt.Errorf("port %d out of range 1-65535", d.Port)
+		}
+		if _, err := net.LookupHost(d.Host); err != nil {
+			return err
+		}
+	}
+	if d.WriteBuffer < 0 || d.WriteBuffer > maxWriteBuffer {
+		return fmt.Errorf("write_buffer %d out of range 0-%d", d.WriteBuffer, maxWriteBuffer)
+	}
+	if d.NoDelay != nil && d.Transport != TransportTCP {
+		return fmt.Errorf("no_delay only applies to tcp")
+	}
+	for _, name := range d.Fields {
+		if _, exists := routeFields[name]; !exists {
+			return fmt.Errorf("unknown field %q, must be position, rotation or velocity", name)
+		}
+	}
+	for name, encoding := range d.Encoding {
+		if _, exists := routeFields[name]; !exists {
+			return fmt.Errorf("unknown encoding field %q, must be position, rotation or velocity", name)
+		}
+		if encoding != EncodingFloat32 && encoding != EncodingFloat64 {
+			return fmt.Errorf("encoding %s: %q must be %s or %s", name, encoding, EncodingFloat32, EncodingFloat64)
+		}
+	}
+	if d.Address != "" {
+		t, err := parseAddressTemplate(d.Address)
+		if err != nil {
+			return fmt.Errorf("address %q: %w", d.Address, err)
+		}
+		if !t.has("{id}") {
+			return fmt.Errorf("address %q needs {id}", d.Address)
+		}
+		// without {field} the fields would be told apart by nothing
+		if !t.has("{field}") && len(d.Fields) != 1 {
+			return fmt.Errorf("address %q needs {field} unless fields selects a single one", d.Address)
+		}
+	}
+	for name, route := range d.Routes {
+		if _, exists := routeFields[name]; !exists {
+			return fmt.Errorf("unknown route %q, must be position, rotation or velocity", name)
+		}
+		if route.Port < 0 || route.Port > 65535 {
+			return fmt.Errorf("route %s: port %d out of range 1-65535", name, route.Port)
+		}
+		if route.Host != "" {
+			if _, err := net.LookupHost(route.Host); err != nil {
+				return fmt.Errorf("route %s: %w", name, err)
+			}
+		}
+	}
+	return validateTransport(d.Transport)
+}
+
+func validateTransport(transport string) error {
+	if transport != "" && transport != TransportUDP && transport != TransportTCP && transport != TransportUnix {
+		return fmt.Errorf("transport %q must be %s, %s or %s", transport, TransportUDP, TransportTCP, TransportUnix)
+	}
+	return nil
+}
+
+// AllListenAddrs returns listen_addr followed by listen_addrs. listen_addr
+// may be set to "" when only the list should be used.
+func (c *Config) AllListenAddrs() []string {
+	var addrs []string
+	if c.ListenAddr != "" {
+		addrs = append(addrs, c.ListenAddr)
+	}
+	return append(addrs, c.ListenAddrs...)
+}
+
+// AllDestinations returns dest_host/dest_port followed by the destinations
+// list. dest_host may be set to "" when only the list should be used.
+func (c *Config) AllDestinations() []Destination {
+	var dests []Destination
+	if c.DestHost != "" {
+		dests = append(dests, Destination{
+			Host:        c.DestHost,
+			Port:        c.DestPort,
+			Transport:   c.DestTransport,
+			Path:        c.DestPath,
+			Routes:      c.DestRoutes,
+			NoDelay:     c.DestNoDelay,
+			WriteBuffer: c.DestWriteBuffer,
+			IDs:         c.DestIDs,
+			Fields:      c.DestFields,
+			Encoding:    c.DestEncoding,
+			Address:     c.DestAddress,
+		})
+	}
+	return append(dests, c.Destinations...)
+}
+
+// sourceName is the namespace of trackers sent from addr, "" when
+// source_namespace is off or the sender is unknown. Unix socket senders are
+// named by their socket path, the file name when source_names has none.
+func (c *Config) sourceName(from net.Addr) string {
+	if !c.SourceNamespace || from == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(from.String())
+	if err != nil {
+		host = from.String()
+	}
+	if name, exists := c.SourceNames[host]; exists {
+		return name
+	}
+	if from.Network() == "unixgram" {
+		return filepath.Base(host)
+	}
+	return host
+}
+
+// Handling of NaN/Inf values
+const (
+	NonFiniteReject = "reject" // drop the whole message
+	NonFiniteClamp  = "clamp"  // replace the bad components with the last good value
+)
+
+// Handling of positions outside position_min/position_max
+const (
+	OutOfBoundsClamp = "clamp" // move the position onto the nearest bound
+	OutOfBoundsDrop  = "drop"  // drop the whole update
+)
+
+// Smoothing algorithms
+const (
+	SmoothingEMA     = "ema"      // exponential moving average weighted by smoothing_factor
+	SmoothingSpring  = "spring"   // critically damped spring with smoothing_half_life
+	SmoothingOneEuro = "one-euro" // One Euro filter with smoothing_min_cutoff, smoothing_beta and smoothing_d_cutoff
+)
+
+// Arguments of the heartbeat message
+const (
+	HeartbeatCounter   = "counter"   // an int32 counting up from 1
+	HeartbeatTimestamp = "timestamp" // the unix time in seconds, as a double
+	HeartbeatNone      = "none"      // no arguments
+)
+
+// Timetags of outgoing bundles
+const (
+	TimetagNow         = "now"         // the time the bundle is sent
+	TimetagPassthrough = "passthrough" // the source time of the updates, now for updates without one
+	TimetagOffset      = "offset"      // followed by a duration added to now, e.g. offset+20ms
+)
+
+// Queue overflow policies
+const (
+	OverflowDropNewest = "drop-newest" // discard the update that did not fit
+	OverflowDropOldest = "drop-oldest" // discard the oldest queued update to make room
+)
+
+// Policies for new trackers beyond max_trackers
+const (
+	LimitReject = "reject" // drop the new tracker's updates
+	LimitEvict  = "evict"  // remove the least recently seen tracker to make room
+)
+
+func DefaultConfig() *Config {
+	return &Config{
+		ListenAddr:        "127.0.0.1:9009",
+		ListenTransport:   TransportUDP,
+		ListenFamily:      FamilyDual,
+		DestHost:          "127.0.0.1",
+		DestPort:          9010,
+		UpdateBufferSize:  10000,
+		ForwardBufferSize: 10000,
+		OverflowPolicy:    OverflowDropNewest,
+		QueueHighWater:    0.8,
+		DrainTimeout:      2 * time.Second,
+		TrackerTTL:        5 * time.Second,
+		SweepInterval:     time.Second,
+		TrackerLimit:      LimitReject,
+		LogLevel:          "info",
+		LogFormat:         "text",
+		LogThrottle:       time.Second,
+
+		Schema: Schema{
+			Namespace: []string{"tracking", "trackers"},
+			IDIndex:   3,
+			IDArg:     -1,
+			Position:  "position",
+			Rotation:  "rotation",
+			Pose:      "pose",
+			Active:    "active",
+		},
+
+		NonFinite:           NonFiniteReject,
+		OutOfBounds:         OutOfBoundsClamp,
+		SmoothingMode:       SmoothingEMA,
+		SmoothingMinCutoff:  1,
+		SmoothingDCutoff:    1,
+		InputFrame:          "unity",
+		InversionThreshold:  170,
+		InversionSamples:    1,
+		VelocityMinInterval: time.Millisecond,
+		BundleMaxSize:       maxDatagramSize,
+		PredictMaxAge:       100 * time.Millisecond,
+
+		SendAttempts:    1,
+		SendRetryDelay:  5 * time.Millisecond,
+		SendRetryBudget: 50 * time.Millisecond,
+
+		HeartbeatAddress: "/oscwrench/alive",
+		HeartbeatPayload: HeartbeatCounter,
+
+		BreakerFailures:      5,
+		BreakerProbeInterval: time.Second,
+
+		TimetagMode: TimetagNow,
+	}
+}
+
+// LoadConfig reads the config file at path on top of the defaults. A missing
+// file is not an error, the defaults are used as-is.
+func LoadConfig(path string) (*Config, error) {
+	cfg := DefaultConfig()
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		slog.Info("Config file not found, using defaults", "path", path)
+		return cfg, cfg.Validate()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading config %s: %w", path, err)
+	}
+
+	// a misspelled key would otherwise be ignored and leave its setting at
+	// the default without a word
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	var errs []error
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			return nil, fmt.Errorf("parsing config %s: %w", path, err)
+		}
+		// the rest of the file is still decoded, so unknown keys and
+		// mismatched values are reported together with the invalid settings
+		for _, msg := range typeErr.Errors {
+			errs = append(errs, errors.New(msg))
+		}
+	}
+
+	if errs = append(errs, cfg.problems()...); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid config %s: %w", path, errors.Join(errs...))
+	}
+
+	return cfg, nil
+}
+
+// restartRequired lists the settings that differ between c and next but
+// only take effect on a restart.
+func (c *Config) restartRequired(next *Config) []string {
+	var changed []string
+	check := func(name string, differs bool) {
+		if differs {
+			changed = append(changed, name)
+		}
+	}
+	check("listen_addr", c.ListenAddr != next.ListenAddr)
+	check("listen_addrs", !slices.Equal(c.ListenAddrs, next.ListenAddrs))
+	check("listen_transport", c.ListenTransport != next.ListenTransport)
+	check("listen_family", c.ListenFamily != next.ListenFamily)
+	check("listener_watchdog", c.ListenerWatchdog != next.ListenerWatchdog)
+	check("update_buffer_size", c.UpdateBufferSize != next.UpdateBufferSize)
+	check("forward_buffer_size", c.ForwardBufferSize != next.ForwardBufferSize)
+	check("tracker_ttl", c.TrackerTTL != next.TrackerTTL)
+	check("sweep_interval", c.SweepInterval != next.SweepInterval)
+	check("debug_addr", c.DebugAddr != next.DebugAddr)
+	check("debug_websocket", c.DebugWebSocket != next.DebugWebSocket)
+	check("stream_addr", c.StreamAddr != next.StreamAddr)
+	check("calibration_file", c.CalibrationFile != next.CalibrationFile)
+	check("disabled_file", c.DisabledFile != next.DisabledFile)
+	return changed
+}
+
+// Validate checks every setting and returns all problems found, joined into
+// one error.
+func (c *Config) Validate() error {
+	return errors.Join(c.problems()...)
+}
+
+// problems checks every setting and lists what is wrong, nil for a valid
+// config.
+func (c *Config) problems() []error {
+	var errs []error
+	addrs := c.AllListenAddrs()
+	if len(addrs) == 0 {
+		errs = append(errs, fmt.Errorf("listen_addr or listen_addrs must be set"))
+	}
+	for _, addr := range addrs {
+		if c.ListenTransport == TransportUnix {
+			if err := validateSocketPath(addr); err != nil {
+				errs = append(errs, fmt.Errorf("listen address %q: %w", addr, err))
+			}
+		} else if _, _, err := net.SplitHostPort(addr); err != nil {
+			errs = append(errs, fmt.Errorf("listen address %q: %w", addr, err))
+		}
+	}
+	if err := validateTransport(c.ListenTransport); err != nil {
+		errs = append(errs, fmt.Errorf("listen_transport: %w", err))
+	}
+	if c.ListenFamily != FamilyDual && c.ListenFamily != FamilyIPv4 && c.ListenFamily != FamilyIPv6 {
+		errs = append(errs, fmt.Errorf("listen_family must be %s, %s or %s", FamilyDual, FamilyIPv4, FamilyIPv6))
+	}
+	if c.ListenerWatchdog < 0 {
+		errs = append(errs, fmt.Errorf("listener_watchdog must not be negative"))
+	}
+	if c.ListenerRestart && c.ListenerWatchdog == 0 {
+		errs = append(errs, fmt.Errorf("listener_restart needs listener_watchdog"))
+	}
+	for i, dest := range c.AllDestinations() {
+		if err := dest.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("destination %d (%s): %w", i, dest, err))
+		}
+	}
+	if c.DebugAddr != "" {
+		if _, _, err := net.SplitHostPort(c.DebugAddr); err != nil {
+			errs = append(errs, fmt.Errorf("debug_addr: %w", err))
+		}
+	}
+	if c.StreamAddr != "" {
+		if _, _, err := net.SplitHostPort(c.StreamAddr); err != nil {
+			errs = append(errs, fmt.Errorf("stream_addr: %w", err))
+		}
+	}
+	if c.UpdateBufferSize < 0 || c.ForwardBufferSize < 0 {
+		errs = append(errs, fmt.Errorf("update_buffer_size and forward_buffer_size must not be negative"))
+	}
+	if c.OverflowPolicy != OverflowDropNewest && c.OverflowPolicy != OverflowDropOldest {
+		errs = append(errs, fmt.Errorf("overflow_policy must be %q or %q", OverflowDropNewest, OverflowDropOldest))
+	}
+	if c.MaxTrackers < 0 {
+		errs = append(errs, fmt.Errorf("max_trackers must not be negative"))
+	}
+	if c.TrackerLimit != LimitReject && c.TrackerLimit != LimitEvict {
+		errs = append(errs, fmt.Errorf("tracker_limit must be %q or %q", LimitReject, LimitEvict))
+	}
+	if c.DrainTimeout < 0 {
+		errs = append(errs, fmt.Errorf("drain_timeout must not be negative"))
+	}
+	if c.QueueHighWater <= 0 || c.QueueHighWater > 1 {
+		errs = append(errs, fmt.Errorf("queue_high_water %v out of range (0,1]", c.QueueHighWater))
+	}
+	if c.NonFinite != NonFiniteReject && c.NonFinite != NonFiniteClamp {
+		errs = append(errs, fmt.Errorf("non_finite must be %q or %q", NonFiniteReject, NonFiniteClamp))
+	}
+	if c.InversionSamples < 1 {
+		errs = append(errs, fmt.Errorf("inversion_samples must be at least 1"))
+	}
+	if len(c.InversionIDs) > 0 && len(c.InversionSkipIDs) > 0 {
+		errs = append(errs, fmt.Errorf("only one of inversion_ids and inversion_skip_ids may be set"))
+	}
+	if c.SmoothingFactor < 0 || c.SmoothingFactor >= 1 {
+		errs = append(errs, fmt.Errorf("smoothing_factor %v out of range [0,1)", c.SmoothingFactor))
+	}
+	for id, factor := range c.SmoothingFactors {
+		if factor < 0 || factor >= 1 {
+			errs = append(errs, fmt.Errorf("smoothing_factors: %v for tracker %d out of range [0,1)", factor, id))
+		}
+	}
+	switch c.SmoothingMode {
+	case SmoothingEMA:
+	case SmoothingSpring:
+		if c.SmoothingHalfLife <= 0 {
+			errs = append(errs, fmt.Errorf("smoothing_half_life must be positive with smoothing_mode spring"))
+		}
+	case SmoothingOneEuro:
+		if c.SmoothingMinCutoff <= 0 || c.SmoothingDCutoff <= 0 {
+			errs = append(errs, fmt.Errorf("smoothing_min_cutoff and smoothing_d_cutoff must be positive with smoothing_mode one-euro"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("smoothing_mode must be %s, %s or %s", SmoothingEMA, SmoothingSpring, SmoothingOneEuro))
+	}
+	if c.SmoothingHalfLife < 0 {
+		errs = append(errs, fmt.Errorf("smoothing_half_life must not be negative"))
+	}
+	if c.SmoothingMinCutoff < 0 || c.SmoothingBeta < 0 || c.SmoothingDCutoff < 0 {
+		errs = append(errs, fmt.Errorf("smoothing_min_cutoff, smoothing_beta and smoothing_d_cutoff must not be negative"))
+	}
+	if _, err := parseLogLevel(c.LogLevel); err != nil {
+		errs = append(errs, fmt.Errorf("log_level: %w", err))
+	}
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		errs = append(errs, fmt.Errorf("log_format must be text or json"))
+	}
+	if c.QueryAddress != "" && !strings.HasPrefix(c.QueryAddress, "/") {
+		errs = append(errs, fmt.Errorf("query_address %q must start with /", c.QueryAddress))
+	}
+	if err := c.Schema.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("schema: %w", err))
+	}
+	if _, err := parseAxisMap(c.PositionAxes); err != nil {
+		errs = append(errs, fmt.Errorf("position_axes: %w", err))
+	}
+	if _, err := parseAxisMap(c.RotationAxes); err != nil {
+		errs = append(errs, fmt.Errorf("rotation_axes: %w", err))
+	}
+	if c.FramePreset != "" {
+		if _, _, err := convertFrame(c.InputFrame, c.FramePreset); err != nil {
+			errs = append(errs, fmt.Errorf("frame_preset: %w", err))
+		}
+		if m, err := parseAxisMap(c.PositionAxes); err == nil && m != identityAxes {
+			errs = append(errs, fmt.Errorf("frame_preset cannot be combined with position_axes"))
+		}
+		if m, err := parseAxisMap(c.RotationAxes); err == nil && m != identityAxes {
+			errs = append(errs, fmt.Errorf("frame_preset cannot be combined with rotation_axes"))
+		}
+	}
+	if _, err := parseMirror(c.Mirror); err != nil {
+		errs = append(errs, fmt.Errorf("mirror: %w", err))
+	}
+	if _, err := parseMatrix(c.PositionMatrix); err != nil {
+		errs = append(errs, fmt.Errorf("position_matrix: %w", err))
+	}
+	if _, err := newScaleOffset(c.PositionScale, c.PositionOffset); err != nil {
+		errs = append(errs, fmt.Errorf("position_scale/position_offset: %w", err))
+	}
+	if _, err := newBounds(c.PositionMin, c.PositionMax); err != nil {
+		errs = append(errs, fmt.Errorf("position_min/position_max: %w", err))
+	}
+	if c.OutOfBounds != OutOfBoundsClamp && c.OutOfBounds != OutOfBoundsDrop {
+		errs = append(errs, fmt.Errorf("out_of_bounds must be %q or %q", OutOfBoundsClamp, OutOfBoundsDrop))
+	}
+	if c.PositionDeadband < 0 || c.RotationDeadband < 0 {
+		errs = append(errs, fmt.Errorf("position_deadband and rotation_deadband must not be negative"))
+	}
+	if c.VelocityMinInterval < 0 {
+		errs = append(errs, fmt.Errorf("velocity_min_interval must not be negative"))
+	}
+	if c.LogThrottle < 0 {
+		errs = append(errs, fmt.Errorf("log_throttle must not be negative"))
+	}
+	if c.JitterWindow < 0 {
+		errs = append(errs, fmt.Errorf("jitter_window must not be negative"))
+	}
+	if c.HistorySize < 0 {
+		errs = append(errs, fmt.Errorf("history_size must not be negative"))
+	}
+	if c.FreezeAfter < 0 || c.FreezeEpsilon < 0 {
+		errs = append(errs, fmt.Errorf("freeze_after and freeze_epsilon must not be negative"))
+	}
+	if c.PredictLead < 0 || c.PredictMaxSpeed < 0 || c.PredictMaxAge < 0 {
+		errs = append(errs, fmt.Errorf("predict_lead, predict_max_speed and predict_max_age must not be negative"))
+	}
+	if c.PredictLead > 0 && !c.Velocity {
+		errs = append(errs, fmt.Errorf("predict_lead needs velocity enabled"))
+	}
+	if c.ForwardEpsilon < 0 {
+		errs = append(errs, fmt.Errorf("forward_epsilon must not be negative"))
+	}
+	if c.PositionStep < 0 || c.RotationStep < 0 {
+		errs = append(errs, fmt.Errorf("position_step and rotation_step must not be negative"))
+	}
+	targets := make(map[int]int, len(c.IDMap))
+	for from, to := range c.IDMap {
+		if other, exists := targets[to]; exists {
+			errs = append(errs, fmt.Errorf("id_map: trackers %d and %d both map to %d", min(from, other), max(from, other), to))
+		}
+		targets[to] = from
+	}
+	names := make(map[string]string, len(c.SourceNames))
+	for host, name := range c.SourceNames {
+		if name == "" || strings.ContainsAny(name, " #*,/?[]{}") {
+			errs = append(errs, fmt.Errorf("source_names: %q is not a valid OSC address segment", name))
+		}
+		if other, exists := names[name]; exists {
+			errs = append(errs, fmt.Errorf("source_names: %s and %s both map to %q", min(host, other), max(host, other), name))
+		}
+		names[name] = host
+	}
+	if c.MaxRate < 0 {
+		errs = append(errs, fmt.Errorf("max_rate must not be negative"))
+	}
+	if c.FrameRate < 0 {
+		errs = append(errs, fmt.Errorf("frame_rate must not be negative"))
+	}
+	if c.FrameRate > 0 && (c.MaxRate > 0 || c.BundleWindow > 0) {
+		errs = append(errs, fmt.Errorf("frame_rate replaces max_rate and bundle_window, use frame_bundle to bundle frames"))
+	}
+	if c.SendAttempts < 1 {
+		errs = append(errs, fmt.Errorf("send_attempts must be at least 1"))
+	}
+	if c.SendRetryDelay < 0 || c.SendRetryBudget < 0 {
+		errs = append(errs, fmt.Errorf("send_retry_delay and send_retry_budget must not be negative"))
+	}
+	if c.HeartbeatInterval < 0 {
+		errs = append(errs, fmt.Errorf("heartbeat_interval must not be negative"))
+	}
+	if c.HeartbeatInterval > 0 && !strings.HasPrefix(c.HeartbeatAddress, "/") {
+		errs = append(errs, fmt.Errorf("heartbeat_address %q must start with /", c.HeartbeatAddress))
+	}
+	if c.HeartbeatPayload != HeartbeatCounter && c.HeartbeatPayload != HeartbeatTimestamp && c.HeartbeatPayload != HeartbeatNone {
+		errs = append(errs, fmt.Errorf("heartbeat_payload must be %s, %s or %s", HeartbeatCounter, HeartbeatTimestamp, HeartbeatNone))
+	}
+	if c.BreakerFailures < 0 {
+		errs = append(errs, fmt.Errorf("breaker_failures must not be negative"))
+	}
+	if c.BreakerFailures > 0 && c.BreakerProbeInterval <= 0 {
+		errs = append(errs, fmt.Errorf("breaker_probe_interval must be positive when breaker_failures is set"))
+	}
+	if c.BundleWindow < 0 {
+		errs = append(errs, fmt.Errorf("bundle_window must not be negative"))
+	}
+	if c.BundleMaxSize < minBundleMaxSize {
+		errs = append(errs, fmt.Errorf("bundle_max_size must be at least %d bytes", minBundleMaxSize))
+	}
+	if _, err := parseTimetagMode(c.TimetagMode); err != nil {
+		errs = append(errs, fmt.Errorf("timetag_mode: %w", err))
+	}
+	if c.TrackerTTL < 0 {
+		errs = append(errs, fmt.Errorf("tracker_ttl must not be negative"))
+	}
+	if c.TrackerTTL > 0 && c.SweepInterval <= 0 {
+		errs = append(errs, fmt.Errorf("sweep_interval must be positive when tracker_ttl is set"))
+	}
+	return errs
+}
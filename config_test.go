@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigUnknownKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	yaml := "listen_adr: 127.0.0.1:9000\nupdate_buffer_size: -1\nschema:\n  id_idx: 3\n"
+	if err := os.WriteFile(path, []byte(yaml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := LoadConfig(path)
+	if err == nil {
+		t.Fatal("a config with unknown keys loaded")
+	}
+	for _, want := range []string{"listen_adr", "id_idx", "update_buffer_size"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %s: %v", want, err)
+		}
+	}
+
+	var out strings.Builder
+	if checkConfig(&out, path, func() (*Config, error) { return LoadConfig(path) }) {
+		t.Fatal("checkConfig passed a config with unknown keys")
+	}
+	if n := strings.Count(out.String(), "\n  - "); n != 3 {
+		t.Errorf("checkConfig listed %d problems, want 3 each on its own line:\n%s", n, out.String())
+	}
+}
+
+func TestLoadConfigEmptyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("an empty config failed to load: %v", err)
+	}
+	if cfg.UpdateBufferSize != DefaultConfig().UpdateBufferSize {
+		t.Errorf("empty config gave update_buffer_size %d, want the default", cfg.UpdateBufferSize)
+	}
+}
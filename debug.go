@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"strconv"
+)
+
+// startDebugServer serves the diagnostic HTTP endpoints on addr, plus the
+// pprof handlers under /debug/pprof/ when withPprof is set and the update
+// stream on /ws when withWebSocket is. The returned server should be closed
+// on shutdown.
+func startDebugServer(addr string, tm *TrackerManager, withPprof, withWebSocket bool) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /trackers", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, tm.GetAllTrackers())
+	})
+	mux.HandleFunc("GET /trackers/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, ok := trackerID(w, r)
+		if !ok {
+			return
+		}
+		tracker, exists := tm.GetTrackerData(r.URL.Query().Get("source"), id)
+		if !exists {
+			http.Error(w, "no such tracker", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, tracker)
+	})
+	mux.HandleFunc("GET /trackers/{id}/history", func(w http.ResponseWriter, r *http.Request) {
+		id, ok := trackerID(w, r)
+		if !ok {
+			return
+		}
+		samples, exists := tm.History(r.URL.Query().Get("source"), id)
+		if !exists {
+			http.Error(w, "no such tracker", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, samples)
+	})
+	mux.HandleFunc("GET /calibrations", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, tm.Calibrations())
+	})
+	mux.HandleFunc("POST /trackers/{id}/calibration", func(w http.ResponseWriter, r *http.Request) {
+		id, ok := trackerID(w, r)
+		if !ok {
+			return
+		}
+		cal, err := tm.Calibrate(r.URL.Query().Get("source"), id)
+		switch {
+		case errors.Is(err, errTrackerNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			writeJSON(w, cal)
+		}
+	})
+	mux.HandleFunc("DELETE /trackers/{id}/calibration", func(w http.ResponseWriter, r *http.Request) {
+		id, ok := trackerID(w, r)
+		if !ok {
+			return
+		}
+		if !tm.ClearCalibration(r.URL.Query().Get("source"), id) {
+			http.Error(w, "tracker is not calibrated", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /disabled", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, tm.DisabledTrackers())
+	})
+	mux.HandleFunc("POST /trackers/{id}/disable", func(w http.ResponseWriter, r *http.Request) {
+		id, ok := trackerID(w, r)
+		if !ok {
+			return
+		}
+		tm.Disable(r.URL.Query().Get("source"), id)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("POST /trackers/{id}/enable", func(w http.ResponseWriter, r *http.Request) {
+		id, ok := trackerID(w, r)
+		if !ok {
+			return
+		}
+		tm.Enable(r.URL.Query().Get("source"), id)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeProbe(w, health.Listening.Load() && health.Forwarding.Load())
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		writeProbe(w, health.Listening.Load() && health.Forwarding.Load() && health.Sent.Load())
+	})
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, metrics.Status(tm))
+	})
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		metrics.WritePrometheus(w, tm)
+	})
+
+	if withWebSocket {
+		mux.HandleFunc("GET /ws", serveWebSocket(tm))
+	}
+	if withPprof {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+
+	srv := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		slog.Info("Starting debug server", "addr", addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Debug server failed", "err", err)
+		}
+	}()
+	return srv
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("Error writing debug response", "err", err)
+	}
+}
+
+func writeProbe(w http.ResponseWriter, ok bool) {
+	if !ok {
+		http.Error(w, "not ok", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
+
+// trackerID reads the {id} path value, answering 400 when it is not a number.
+func trackerID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid tracker id", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"log/slog"
+	"sort"
+)
+
+// DisabledTracker names a tracker switched off at runtime.
+type DisabledTracker struct {
+	Source string `json:"source,omitempty"`
+	ID     int    `json:"id"`
+}
+
+// Disable stops forwarding the tracker. It is still tracked, so enabling it
+// again picks up its current state. A tracker that has not been seen yet can
+// be disabled ahead of time. It reports whether the tracker was enabled.
+func (tm *TrackerManager) Disable(source string, id int) bool {
+	return tm.setDisabled(source, id, true)
+}
+
+// Enable resumes forwarding the tracker, it reports whether it was disabled.
+func (tm *TrackerManager) Enable(source string, id int) bool {
+	return tm.setDisabled(source, id, false)
+}
+
+func (tm *TrackerManager) setDisabled(source string, id int, disabled bool) bool {
+	key := trackerKey{source: source, id: id}
+	tm.mu.Lock()
+	changed := tm.disabled[key] != disabled
+	if disabled {
+		tm.disabled[key] = true
+	} else {
+		delete(tm.disabled, key)
+	}
+	if tracker, exists := tm.trackers[key]; exists {
+		tracker.Disabled = disabled
+	}
+	tm.mu.Unlock()
+
+	if changed {
+		if disabled {
+			slog.Info("Tracker disabled", "tracker", id, "source", source)
+		} else {
+			slog.Info("Tracker enabled", "tracker", id, "source", source)
+		}
+		tm.saveDisabled()
+	}
+	return changed
+}
+
+// DisabledTrackers returns the trackers switched off, sorted like
+// GetAllTrackers.
+func (tm *TrackerManager) DisabledTrackers() []DisabledTracker {
+	tm.mu.RLock()
+	out := make([]DisabledTracker, 0, len(tm.disabled))
+	for key := range tm.disabled {
+		out = append(out, DisabledTracker{Source: key.source, ID: key.id})
+	}
+	tm.mu.RUnlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Source != out[j].Source {
+			return out[i].Source < out[j].Source
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+// LoadDisabled reads the trackers saved as disabled in path and keeps saving
+// changes there. A missing file starts out with every tracker enabled.
+func (tm *TrackerManager) LoadDisabled(path string) error {
+	var disabled []DisabledTracker
+	if err := tm.disabledStore.load(path, &disabled); err != nil {
+		return err
+	}
+
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	for _, d := range disabled {
+		tm.disabled[trackerKey{source: d.Source, id: d.ID}] = true
+	}
+	return nil
+}
+
+// saveDisabled writes the disabled trackers to the disabled file.
+func (tm *TrackerManager) saveDisabled() {
+	tm.disabledStore.save("disabled trackers", func() any { return tm.DisabledTrackers() })
+}
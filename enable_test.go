@@ -0,0 +1,74 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDisableToggle(t *testing.T) {
+	tm := newTestManager(t, DefaultConfig())
+	updates, unsubscribe := tm.Subscribe()
+	defer unsubscribe()
+	f, senders := newTestForwarder(testConfig())
+
+	if !tm.Disable("", 1) || tm.Disable("", 1) {
+		t.Fatal("Disable did not report only the first call as a change")
+	}
+	tm.process(position(1, 1, 2, 3))
+	disabled := forwarded(tm)
+	if len(disabled) != 1 || !disabled[0].Disabled {
+		t.Fatalf("forwarded %+v, want one disabled update", disabled)
+	}
+	select {
+	case data := <-updates:
+		t.Errorf("subscriber got %+v of a disabled tracker", data)
+	default:
+	}
+
+	if !tm.Enable("", 1) || tm.Enable("", 1) {
+		t.Fatal("Enable did not report only the first call as a change")
+	}
+	tm.process(position(1, 1, 2, 3))
+	enabled := forwarded(tm)
+	if len(enabled) != 1 || enabled[0].Disabled {
+		t.Fatalf("forwarded %+v, want one enabled update", enabled)
+	}
+	select {
+	case data := <-updates:
+		if data.ID != 1 || data.Disabled {
+			t.Errorf("subscriber got %+v, want the enabled update of tracker 1", data)
+		}
+	default:
+		t.Error("subscriber got nothing once the tracker was enabled")
+	}
+
+	runForwarder(f, disabled[0], enabled[0])
+	if msgs := senders[0].messages(); len(msgs) != 1 || msgs[0].Address != "/tracking/trackers/1/position" {
+		t.Errorf("sent %v, want only the position of the enabled update", msgs)
+	}
+}
+
+func TestDisabledFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "disabled.json")
+	tm := newTestManager(t, DefaultConfig())
+	if err := tm.LoadDisabled(path); err != nil {
+		t.Fatalf("missing file: %v", err)
+	}
+	tm.Disable("right", 2)
+	tm.Disable("", 5)
+	tm.Disable("", 1)
+	tm.Enable("", 5)
+
+	want := []DisabledTracker{{ID: 1}, {Source: "right", ID: 2}}
+	if got := tm.DisabledTrackers(); !reflect.DeepEqual(got, want) {
+		t.Errorf("DisabledTrackers() = %+v, want %+v", got, want)
+	}
+	loaded := newTestManager(t, DefaultConfig())
+	if err := loaded.LoadDisabled(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := loaded.DisabledTrackers(); !reflect.DeepEqual(got, want) {
+		t.Errorf("loaded %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,819 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/crgimenes/go-osc"
+	"io"
+	"log/slog"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// OSCSender sends an OSC packet somewhere, *osc.Client satisfies it. Tests can
+// swap in a fake to capture what the forwarder sends.
+type OSCSender interface {
+	Send(packet osc.Packet) error
+}
+
+// dryRunSender stands in for a real sender in --dry-run mode and only logs
+// what would have been sent.
+type dryRunSender struct {
+	addr string
+}
+
+func (s dryRunSender) Send(packet osc.Packet) error {
+	switch p := packet.(type) {
+	case *osc.Message:
+		slog.Debug("Dry run, not sending", "destination", s.addr, "message", p.String())
+	case *osc.Bundle:
+		for _, msg := range p.Messages {
+			slog.Debug("Dry run, not sending", "destination", s.addr, "message", msg.String(), "bundle", true)
+		}
+	}
+	return nil
+}
+
+// destination is a forwarding target with its own sender
+type destination struct {
+	addr    string
+	sender  OSCSender
+	retry   retryPolicy
+	breaker breaker
+	fields  uint8            // fields routed to this destination
+	ids     map[int]bool     // when set, only these tracker IDs are sent here
+	doubles uint8            // fields whose values are sent as float64
+	address *addressTemplate // output address of tracker values, nil for defaultAddress
+}
+
+// encode returns msg for d: under d's address for field of tracker id from
+// source, and with its float32 arguments widened to float64 when d wants
+// field that way. msg itself is returned when neither applies.
+func (d *destination) encode(msg *osc.Message, field uint8, source string, id int) *osc.Message {
+	readdress := d.address != nil && field != fieldRaw
+	if d.doubles&field == 0 && !readdress {
+		return msg
+	}
+	address := msg.Address
+	if readdress {
+		address = d.address.render(source, id, addressField(field))
+	}
+	out := osc.NewMessage(address)
+	for _, arg := range msg.Arguments {
+		if v, ok := arg.(float32); ok && d.doubles&field != 0 {
+			arg = float64(v)
+		}
+		out.Append(arg)
+	}
+	return out
+}
+
+// wants reports whether a message carrying field of tracker id goes to d.
+// Passthrough messages have no tracker, so the ID filter does not apply.
+func (d *destination) wants(field uint8, id int) bool {
+	if d.fields&field == 0 {
+		return false
+	}
+	return field == fieldRaw || d.ids == nil || d.ids[id]
+}
+
+// retryPolicy controls how often a failed send is retried
+type retryPolicy struct {
+	attempts int           // total tries, 1 means no retries
+	delay    time.Duration // before the first retry, doubled after each one
+	budget   time.Duration // retries stop once the next one would end past this
+}
+
+func newRetryPolicy(cfg *Config) retryPolicy {
+	return retryPolicy{
+		attempts: cfg.SendAttempts,
+		delay:    cfg.SendRetryDelay,
+		budget:   cfg.SendRetryBudget,
+	}
+}
+
+// breaker stops sending to a destination after consecutive failures, so a
+// dead one does not flood the log or cost a syscall per message. While it is
+// down one packet per probeInterval is let through as a probe, the first one
+// that succeeds brings it back up. It is only used from the forwarder.
+type breaker struct {
+	threshold     int // consecutive failures that take the destination down, 0 disables
+	probeInterval time.Duration
+
+	failures  int
+	down      bool
+	nextProbe time.Time
+}
+
+func newBreaker(cfg *Config) breaker {
+	return breaker{threshold: cfg.BreakerFailures, probeInterval: cfg.BreakerProbeInterval}
+}
+
+// allow reports whether a packet should be sent at now.
+func (b *breaker) allow(now time.Time) bool {
+	if !b.down {
+		return true
+	}
+	if now.Before(b.nextProbe) {
+		return false
+	}
+	b.nextProbe = now.Add(b.probeInterval)
+	return true
+}
+
+// failed records a failed send and reports whether it took the destination
+// down.
+func (b *breaker) failed(now time.Time) bool {
+	b.failures++
+	if b.threshold <= 0 || b.down || b.failures < b.threshold {
+		return false
+	}
+	b.down = true
+	b.nextProbe = now.Add(b.probeInterval)
+	return true
+}
+
+// succeeded records a successful send and reports whether it brought the
+// destination back up.
+func (b *breaker) succeeded() bool {
+	b.failures = 0
+	recovered := b.down
+	b.down = false
+	return recovered
+}
+
+// send sends packet, retrying with exponential backoff within the budget so a
+// dead destination cannot stall the forwarder for long.
+func (d *destination) send(packet osc.Packet) error {
+	deadline := time.Now().Add(d.retry.budget)
+	delay := d.retry.delay
+	for attempt := 1; ; attempt++ {
+		err := d.sender.Send(packet)
+		if err == nil || attempt >= d.retry.attempts || time.Now().Add(delay).After(deadline) {
+			return err
+		}
+		slog.Debug("Retrying send", "destination", d.addr, "attempt", attempt, "err", err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// newDestinations builds a sender per destination and route, with dryRun
+// nothing is actually sent. Routes pointing at the same address share one
+// sender, which gets the union of their fields and tracker IDs. Each
+// destination gets its own copy of brk.
+func newDestinations(dests []Destination, dryRun bool, retry retryPolicy, brk breaker) []*destination {
+	var out []*destination
+	byAddr := make(map[string]*destination)
+	for _, d := range dests {
+		for _, ep := range d.endpoints() {
+			key := ep.Transport + " " + ep.String() + " " + ep.Address
+			if existing, exists := byAddr[key]; exists {
+				existing.fields |= ep.fields
+				existing.doubles |= ep.doubles() & ep.fields
+				if existing.ids != nil {
+					if len(ep.IDs) == 0 {
+						existing.ids = nil
+					}
+					for _, id := range ep.IDs {
+						existing.ids[id] = true
+					}
+				}
+				continue
+			}
+			opts := socketOptions{noDelay: ep.NoDelay, writeBuffer: ep.WriteBuffer}
+			var sender OSCSender = newUDPSender(ep.Host, ep.Port, opts)
+			if dryRun {
+				sender = dryRunSender{addr: ep.String()}
+			} else if ep.Transport == TransportTCP {
+				sender = newTCPSender(ep.Host, ep.Port, opts)
+			} else if ep.Transport == TransportUnix {
+				sender = newUnixSender(ep.Path, opts)
+			}
+			dest := &destination{
+				addr:    ep.String(),
+				sender:  sender,
+				retry:   retry,
+				breaker: brk,
+				fields:  ep.fields,
+				ids:     idSet(ep.IDs),
+				doubles: ep.doubles() & ep.fields,
+			}
+			if ep.Address != "" && ep.Address != defaultAddress {
+				address, _ := parseAddressTemplate(ep.Address) // checked by Destination.Validate
+				dest.address = &address
+			}
+			byAddr[key] = dest
+			out = append(out, dest)
+		}
+	}
+	return out
+}
+
+// sendAll sends msg, in the encoding each destination asks for, to every
+// destination that wants field of tracker id. A failing destination does not
+// keep the others from receiving it. attrs are added to the error log.
+func sendAll(dests []*destination, field uint8, source string, id int, msg *osc.Message, what string, attrs ...any) {
+	for _, dest := range dests {
+		if dest.wants(field, id) {
+			sendTo(dest, dest.encode(msg, field, source, id), what, attrs...)
+		}
+	}
+}
+
+func sendTo(dest *destination, packet osc.Packet, what string, attrs ...any) {
+	now := time.Now()
+	if !dest.breaker.allow(now) {
+		metrics.Skipped.Inc(dest.addr)
+		return
+	}
+	if err := dest.send(packet); err != nil {
+		metrics.SendErrors.Inc(dest.addr)
+		if dest.breaker.failed(now) {
+			metrics.DestinationDown.Set(dest.addr, 1)
+			slog.Warn("Destination down, sending only an occasional probe until it recovers",
+				"destination", dest.addr, "failures", dest.breaker.failures, "err", err)
+		} else if !dest.breaker.down {
+			errorLog.Warn(dest.addr+" "+err.Error(), "Error sending "+what, append([]any{"destination", dest.addr, "err", err}, attrs...)...)
+		}
+		return
+	}
+	if dest.breaker.succeeded() {
+		metrics.DestinationDown.Set(dest.addr, 0)
+		slog.Info("Destination back up", "destination", dest.addr)
+	}
+	metrics.Forwarded.Inc(dest.addr)
+	health.Sent.Store(true)
+}
+
+// forwarder sends tracker updates to all destinations
+type forwarder struct {
+	dests      []*destination
+	epsilon    float64
+	alwaysSend bool
+
+	positionStep float64                     // forwarded positions are rounded to multiples of this, 0 disables
+	rotationStep float64                     // same for Euler rotations
+	last         map[trackerKey]*TrackerData // last forwarded values per tracker
+
+	bundleWindow  time.Duration    // when > 0 messages are collected and sent as one bundle per window
+	pending       []pendingMessage // messages waiting for the next bundle flush
+	bundleMaxSize int              // bytes, a bundle is split before it grows bigger
+	timetag       timetagMode      // how bundles are stamped
+
+	predictLead     time.Duration                 // project positions this far ahead using the velocity, 0 disables
+	predictMaxSpeed float64                       // velocities are clamped to this magnitude for prediction, 0 is unlimited
+	predictMaxAge   time.Duration                 // updates older than this are not projected
+	velocities      map[trackerKey]velocitySample // last known velocity per tracker
+
+	rateInterval time.Duration               // when > 0 each tracker is forwarded at most once per interval
+	coalesced    map[trackerKey]*TrackerData // updates merged since the last rate tick
+
+	frameInterval time.Duration               // when > 0 all trackers are forwarded once per interval instead of per update
+	frameBundle   bool                        // send each frame as one bundle
+	frameTTL      time.Duration               // trackers not updated for this long leave the frame, 0 keeps them
+	skipInactive  bool                        // leave out trackers reported inactive
+	frame         map[trackerKey]*TrackerData // latest state of every tracker for the next frame
+
+	idMap       map[int]int // tracker ID -> ID used in outgoing addresses
+	idMapStrict bool        // drop trackers missing from idMap instead of passing them through
+
+	logLatency bool // log the latency of each message that has a source time
+
+	heartbeatInterval time.Duration // when > 0 a keepalive goes to every destination this often
+	heartbeatAddress  string
+	heartbeatPayload  string // see Heartbeat*
+	heartbeats        int32  // keepalives sent so far, the counter payload
+
+	reload chan forwarderReload // see Reload
+	raw    chan *osc.Message    // see Passthrough
+}
+
+// pendingMessage is a message queued for the next bundle
+type pendingMessage struct {
+	msg        *osc.Message
+	field      uint8
+	source     string    // namespace of the tracker, unused for passthrough messages
+	id         int       // forwarded tracker ID, unused for passthrough messages
+	sourceTime time.Time // of the update the message came from
+}
+
+// timetagMode decides the timetag of outgoing bundles, see parseTimetagMode
+type timetagMode struct {
+	passthrough bool          // use the source time of the update when known
+	offset      time.Duration // added to the flush time otherwise
+}
+
+// parseTimetagMode parses "now", "passthrough" or "offset" followed by a
+// signed duration, e.g. "offset+20ms".
+func parseTimetagMode(mode string) (timetagMode, error) {
+	switch {
+	case mode == TimetagNow:
+		return timetagMode{}, nil
+	case mode == TimetagPassthrough:
+		return timetagMode{passthrough: true}, nil
+	case strings.HasPrefix(mode, TimetagOffset):
+		offset, err := time.ParseDuration(strings.TrimPrefix(mode, TimetagOffset))
+		if err != nil {
+			return timetagMode{}, fmt.Errorf("bad offset in %q: %w", mode, err)
+		}
+		return timetagMode{offset: offset}, nil
+	}
+	return timetagMode{}, fmt.Errorf("%q must be %s, %s or %s+<duration>", mode, TimetagNow, TimetagPassthrough, TimetagOffset)
+}
+
+// stamp returns the timetag for a message from an update with sourceTime,
+// flushed at now.
+func (m timetagMode) stamp(now, sourceTime time.Time) time.Time {
+	if m.passthrough && !sourceTime.IsZero() {
+		return sourceTime
+	}
+	return now.Add(m.offset)
+}
+
+// forwarderReload hands a new config and destinations to the running forwarder
+type forwarderReload struct {
+	cfg   *Config
+	dests []*destination
+}
+
+func newForwarder(dests []*destination, cfg *Config) *forwarder {
+	f := &forwarder{
+		dests:      dests,
+		last:       make(map[trackerKey]*TrackerData),
+		coalesced:  make(map[trackerKey]*TrackerData),
+		velocities: make(map[trackerKey]velocitySample),
+		frame:      make(map[trackerKey]*TrackerData),
+		reload:     make(chan forwarderReload),
+		raw:        make(chan *osc.Message, cfg.ForwardBufferSize),
+	}
+	f.configure(cfg)
+	return f
+}
+
+// configure applies the forwarding settings from cfg.
+func (f *forwarder) configure(cfg *Config) {
+	f.epsilon = cfg.ForwardEpsilon
+	f.alwaysSend = cfg.ForwardAlways || cfg.FrameRate > 0 // a frame carries every tracker, changed or not
+	f.positionStep = cfg.PositionStep
+	f.rotationStep = cfg.RotationStep
+	f.bundleWindow = cfg.BundleWindow
+	f.bundleMaxSize = cfg.BundleMaxSize
+	f.rateInterval = 0
+	if cfg.MaxRate > 0 {
+		f.rateInterval = time.Duration(float64(time.Second) / cfg.MaxRate)
+	}
+	f.frameInterval = 0
+	if cfg.FrameRate > 0 {
+		f.frameInterval = time.Duration(float64(time.Second) / cfg.FrameRate)
+	} else {
+		clear(f.frame)
+	}
+	f.frameBundle = cfg.FrameBundle
+	f.frameTTL = cfg.TrackerTTL
+	f.skipInactive = cfg.SkipInactive
+	f.idMap = cfg.IDMap
+	f.idMapStrict = cfg.IDMapStrict
+	f.timetag, _ = parseTimetagMode(cfg.TimetagMode) // checked by Config.Validate
+	f.predictLead = cfg.PredictLead
+	f.predictMaxSpeed = cfg.PredictMaxSpeed
+	f.predictMaxAge = cfg.PredictMaxAge
+	f.logLatency = cfg.LogLatency
+	f.heartbeatInterval = cfg.HeartbeatInterval
+	f.heartbeatAddress = cfg.HeartbeatAddress
+	f.heartbeatPayload = cfg.HeartbeatPayload
+}
+
+// Reload swaps in cfg and dests once the forwarder is done with the current
+// update. Whatever is pending for the old destinations is sent first, and the
+// old destinations are closed. It must not be called after forwardCh closed.
+func (f *forwarder) Reload(cfg *Config, dests []*destination) {
+	f.reload <- forwarderReload{cfg: cfg, dests: dests}
+}
+
+// Passthrough queues msg to be forwarded unchanged, it is dropped when the
+// queue is full.
+func (f *forwarder) Passthrough(msg *osc.Message) {
+	select {
+	case f.raw <- msg:
+	default:
+		metrics.Dropped.Inc("passthrough")
+	}
+}
+
+// forwardUpdatedData forwards the updates from forwardCh until it is closed,
+// then sends what is still held back and returns. When ctx is done first it
+// returns right away, dropping whatever is left.
+func (f *forwarder) forwardUpdatedData(ctx context.Context, forwardCh <-chan TrackerData) {
+	health.Forwarding.Store(true)
+	defer health.Forwarding.Store(false)
+	if len(f.dests) == 0 {
+		health.Sent.Store(true)
+	}
+
+	// flush on tickers rather than after each read so nothing is held back
+	// when the channel goes quiet
+	bundleTicker, bundleTick := newTicker(f.bundleWindow)
+	rateTicker, rateTick := newTicker(f.rateInterval)
+	frameTicker, frameTick := newTicker(f.frameInterval)
+	heartbeatTicker, heartbeatTick := newTicker(f.heartbeatInterval)
+	defer func() {
+		stopTicker(bundleTicker)
+		stopTicker(rateTicker)
+		stopTicker(frameTicker)
+		stopTicker(heartbeatTicker)
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case data, ok := <-forwardCh:
+			if !ok {
+				f.forwardCoalesced()
+				f.flush()
+				return
+			}
+			if data.Removed {
+				f.forget(data.key())
+			} else if f.frameInterval > 0 {
+				f.track(data)
+			} else if f.rateInterval > 0 {
+				f.coalesce(data)
+			} else {
+				f.forward(data)
+			}
+		case msg := <-f.raw:
+			if f.bundleWindow > 0 {
+				f.pending = append(f.pending, pendingMessage{msg: msg, field: fieldRaw})
+			} else {
+				sendAll(f.dests, fieldRaw, "", 0, msg, "passthrough message", "address", msg.Address)
+			}
+		case <-rateTick:
+			f.forwardCoalesced()
+		case now := <-frameTick:
+			f.forwardFrame(now)
+		case <-bundleTick:
+			f.flush()
+		case now := <-heartbeatTick:
+			f.heartbeat(now)
+		case r := <-f.reload:
+			f.forwardCoalesced()
+			f.flush()
+			closeDestinations(f.dests)
+			f.dests = r.dests
+			f.configure(r.cfg)
+			// the new destinations have seen nothing yet
+			f.last = make(map[trackerKey]*TrackerData)
+			if len(f.dests) == 0 {
+				health.Sent.Store(true)
+			}
+
+			stopTicker(bundleTicker)
+			stopTicker(rateTicker)
+			stopTicker(frameTicker)
+			stopTicker(heartbeatTicker)
+			bundleTicker, bundleTick = newTicker(f.bundleWindow)
+			rateTicker, rateTick = newTicker(f.rateInterval)
+			frameTicker, frameTick = newTicker(f.frameInterval)
+			heartbeatTicker, heartbeatTick = newTicker(f.heartbeatInterval)
+		}
+	}
+}
+
+// heartbeat sends the keepalive to every destination and route, whatever
+// their filters, so consumers can tell the relay is alive while no trackers
+// are active.
+func (f *forwarder) heartbeat(now time.Time) {
+	f.heartbeats++
+	msg := osc.NewMessage(f.heartbeatAddress)
+	switch f.heartbeatPayload {
+	case HeartbeatCounter:
+		msg.Append(f.heartbeats)
+	case HeartbeatTimestamp:
+		msg.Append(float64(now.UnixNano()) / 1e9)
+	}
+	for _, dest := range f.dests {
+		sendTo(dest, msg, "heartbeat")
+	}
+}
+
+// newTicker returns a ticker and its channel, or nil and a nil channel that
+// never fires when interval is not positive.
+func newTicker(interval time.Duration) (*time.Ticker, <-chan time.Time) {
+	if interval <= 0 {
+		return nil, nil
+	}
+	ticker := time.NewTicker(interval)
+	return ticker, ticker.C
+}
+
+func stopTicker(ticker *time.Ticker) {
+	if ticker != nil {
+		ticker.Stop()
+	}
+}
+
+// closeDestinations releases senders that hold a connection.
+func closeDestinations(dests []*destination) {
+	for _, dest := range dests {
+		if dest.breaker.down {
+			metrics.DestinationDown.Set(dest.addr, 0)
+		}
+		if c, ok := dest.sender.(io.Closer); ok {
+			c.Close()
+		}
+	}
+}
+
+// forget drops everything kept about the tracker once the manager removed
+// it, so trackers coming and going do not pile up here, and a tracker that
+// comes back starts over instead of being predicted along its old velocity.
+func (f *forwarder) forget(key trackerKey) {
+	delete(f.last, key)
+	delete(f.velocities, key)
+	delete(f.coalesced, key)
+	delete(f.frame, key)
+}
+
+// coalesce merges data into the update waiting for the next rate tick.
+func (f *forwarder) coalesce(data TrackerData) {
+	pending, exists := f.coalesced[data.key()]
+	if !exists {
+		f.coalesced[data.key()] = &data
+		return
+	}
+	pending.merge(data)
+}
+
+// forwardCoalesced forwards the latest value of every tracker that was
+// updated since the last rate tick, so a busy tracker cannot starve the others.
+func (f *forwarder) forwardCoalesced() {
+	for key, data := range f.coalesced {
+		f.forward(*data)
+		delete(f.coalesced, key)
+	}
+}
+
+// track merges data into the state forwarded with the next frame.
+func (f *forwarder) track(data TrackerData) {
+	tracked, exists := f.frame[data.key()]
+	if !exists {
+		tracked = &TrackerData{ID: data.ID, Source: data.Source}
+		f.frame[data.key()] = tracked
+	}
+	tracked.merge(data)
+}
+
+// forwardFrame forwards the latest state of every tracker, in source and ID
+// order, as one bundle with frameBundle set. Trackers that stopped updating
+// for longer than the tracker TTL are dropped first, so a frame holds the
+// same trackers the manager still knows.
+func (f *forwarder) forwardFrame(now time.Time) {
+	keys := make([]trackerKey, 0, len(f.frame))
+	for key, data := range f.frame {
+		if f.frameTTL > 0 && now.Sub(data.LastSeen) > f.frameTTL {
+			delete(f.frame, key)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].source != keys[j].source {
+			return keys[i].source < keys[j].source
+		}
+		return keys[i].id < keys[j].id
+	})
+	for _, key := range keys {
+		if data := f.frame[key]; !f.skipInactive || !data.Inactive {
+			f.forward(*data)
+		}
+	}
+	if f.frameBundle {
+		f.flush()
+	}
+}
+
+// send delivers msg right away, or queues it for the next bundle when bundling
+// is enabled.
+func (f *forwarder) send(msg *osc.Message, field uint8, id int, what string, data *TrackerData) {
+	if f.bundleWindow > 0 || f.frameBundle && f.frameInterval > 0 {
+		f.pending = append(f.pending, pendingMessage{msg: msg, field: field, source: data.Source, id: id, sourceTime: data.SourceTime})
+		return
+	}
+	sendAll(f.dests, field, data.Source, id, msg, what, data.logAttrs()...)
+	f.observeLatency(msg, data.SourceTime, time.Now())
+}
+
+// observeLatency records the time from sourceTime to now. Without a source
+// timetag there is nothing to measure, and a negative latency means the
+// sender's clock is ahead, so neither is recorded.
+func (f *forwarder) observeLatency(msg *osc.Message, sourceTime, now time.Time) {
+	if sourceTime.IsZero() {
+		return
+	}
+	latency := now.Sub(sourceTime)
+	if latency < 0 {
+		return
+	}
+	metrics.Latency.Observe(latency.Seconds())
+	if f.logLatency {
+		slog.Debug("Forwarded", "addr", msg.Address, "latency", latency)
+	}
+}
+
+// sizedBundle is a bundle being filled and its encoded size so far.
+type sizedBundle struct {
+	*osc.Bundle
+	size int
+}
+
+// bundleHeaderSize is the encoded size of an empty bundle, "#bundle" and the
+// timetag.
+const bundleHeaderSize = 16
+
+// bundleElementSize returns how much msg adds to an encoded bundle, itself
+// and its size prefix.
+func bundleElementSize(msg *osc.Message) int {
+	b, err := msg.MarshalBinary()
+	if err != nil {
+		return 0 // fails to send anyway
+	}
+	return 4 + len(b)
+}
+
+// flush sends all pending messages to each destination the fields are routed
+// to, as one bundle per timetag. Except in passthrough mode all messages get
+// the same timetag and so end up in a single bundle. A bundle that would grow
+// past bundleMaxSize is split.
+func (f *forwarder) flush() {
+	if len(f.pending) == 0 {
+		return
+	}
+	now := time.Now()
+	for _, dest := range f.dests {
+		var bundles []*osc.Bundle
+		byTime := make(map[time.Time]*sizedBundle)
+		for _, p := range f.pending {
+			if !dest.wants(p.field, p.id) {
+				continue
+			}
+			timetag := f.timetag.stamp(now, p.sourceTime)
+			msg := dest.encode(p.msg, p.field, p.source, p.id)
+			size := bundleElementSize(msg)
+			bundle, exists := byTime[timetag]
+			if !exists || bundle.size+size > f.bundleMaxSize && len(bundle.Messages) > 0 {
+				// a message too big on its own still goes out, alone
+				bundle = &sizedBundle{Bundle: osc.NewBundle(timetag), size: bundleHeaderSize}
+				byTime[timetag] = bundle
+				bundles = append(bundles, bundle.Bundle)
+			}
+			bundle.Append(msg)
+			bundle.size += size
+		}
+		for _, bundle := range bundles {
+			sendTo(dest, bundle, "bundle")
+		}
+	}
+	sent := time.Now()
+	for _, p := range f.pending {
+		f.observeLatency(p.msg, p.sourceTime, sent)
+	}
+	f.pending = f.pending[:0]
+}
+
+// forward sends the fields data carries, skipping the ones that did not change
+// by more than epsilon since they were last sent.
+func (f *forwarder) forward(data TrackerData) {
+	if data.Disabled {
+		// forget what was sent, so everything goes out again once enabled
+		delete(f.last, data.key())
+		return
+	}
+	outID, ok := f.outputID(data.ID)
+	if !ok {
+		return
+	}
+
+	if f.predictLead > 0 {
+		f.predict(&data)
+	}
+	// data is the forwarder's copy, the tracker state and the velocity
+	// derived from it keep full precision
+	data.Position = quantize(data.Position, f.positionStep)
+	data.Rotation = quantize(data.Rotation, f.rotationStep)
+
+	last, exists := f.last[data.key()]
+	if !exists {
+		last = &TrackerData{ID: data.ID, Source: data.Source}
+		f.last[data.key()] = last
+	}
+
+	// Send position
+	if f.shouldSend(data, last, FieldPosition, data.Position[:], last.Position[:]) {
+		f.send(newFloatMessage(defaultAddressTemplate.render(data.Source, outID, "position"), data.Position[:]), FieldPosition, outID, "position", &data)
+		last.Position = data.Position
+		last.Fields |= FieldPosition
+	}
+
+	// Send rotation
+	if f.shouldSend(data, last, FieldRotation, data.Rotation[:], last.Rotation[:]) {
+		f.send(newFloatMessage(defaultAddressTemplate.render(data.Source, outID, "rotation"), data.Rotation[:]), FieldRotation, outID, "rotation", &data)
+		last.Rotation = data.Rotation
+		last.Fields |= FieldRotation
+	}
+
+	// Send quaternion rotation
+	if f.shouldSend(data, last, FieldQuaternion, data.Quaternion[:], last.Quaternion[:]) {
+		f.send(newFloatMessage(defaultAddressTemplate.render(data.Source, outID, "rotation"), data.Quaternion[:]), FieldQuaternion, outID, "quaternion", &data)
+		last.Quaternion = data.Quaternion
+		last.Fields |= FieldQuaternion
+	}
+
+	// Send velocity
+	if f.shouldSend(data, last, FieldVelocity, data.Velocity[:], last.Velocity[:]) {
+		f.send(newFloatMessage(defaultAddressTemplate.render(data.Source, outID, "velocity"), data.Velocity[:]), FieldVelocity, outID, "velocity", &data)
+		last.Velocity = data.Velocity
+		last.Fields |= FieldVelocity
+	}
+}
+
+// velocitySample is a velocity and the arrival of the update it was derived
+// from.
+type velocitySample struct {
+	value [3]float32
+	at    time.Time
+}
+
+// predict projects the position of data predictLead ahead along the last
+// known velocity. Fresh updates and velocities only, so a tracker that stopped
+// sending is not pushed further and further away, and one that comes back is
+// not pushed along the way it was moving before. The age of the velocity is
+// its own, a coalesced update may carry a velocity older than its LastSeen.
+func (f *forwarder) predict(data *TrackerData) {
+	if data.Fields&FieldVelocity != 0 {
+		f.velocities[data.key()] = velocitySample{value: data.Velocity, at: data.VelocityTime}
+	}
+	sample, known := f.velocities[data.key()]
+	if !known || data.Fields&FieldPosition == 0 || time.Since(data.LastSeen) > f.predictMaxAge ||
+		data.LastSeen.Sub(sample.at) > f.predictMaxAge {
+		return
+	}
+	velocity := sample.value
+
+	scale := f.predictLead.Seconds()
+	if f.predictMaxSpeed > 0 {
+		speed := math.Sqrt(float64(velocity[0]*velocity[0] + velocity[1]*velocity[1] + velocity[2]*velocity[2]))
+		if speed > f.predictMaxSpeed {
+			scale *= f.predictMaxSpeed / speed
+		}
+	}
+	for i := 0; i < 3; i++ {
+		data.Position[i] += float32(float64(velocity[i]) * scale)
+	}
+}
+
+// quantize rounds each component of v to the nearest multiple of step, a
+// step of 0 leaves v as it is.
+func quantize(v [3]float32, step float64) [3]float32 {
+	if step <= 0 {
+		return v
+	}
+	for i := range v {
+		v[i] = float32(math.Round(float64(v[i])/step) * step)
+	}
+	return v
+}
+
+// outputID maps a tracker ID to the one presented to destinations, ok is
+// false when the tracker should not be forwarded at all.
+func (f *forwarder) outputID(id int) (int, bool) {
+	if out, exists := f.idMap[id]; exists {
+		return out, true
+	}
+	return id, !f.idMapStrict
+}
+
+func (f *forwarder) shouldSend(data TrackerData, last *TrackerData, field uint8, values, lastValues []float32) bool {
+	if data.Fields&field == 0 {
+		return false
+	}
+	if f.alwaysSend || last.Fields&field == 0 {
+		return true
+	}
+	for i := range values {
+		if math.Abs(float64(values[i]-lastValues[i])) > f.epsilon {
+			return true
+		}
+	}
+	return false
+}
+
+func newFloatMessage(addr string, values []float32) *osc.Message {
+	msg := osc.NewMessage(addr)
+	for _, v := range values {
+		msg.Append(v)
+	}
+	return msg
+}
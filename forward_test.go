@@ -0,0 +1,401 @@
+package main
+
+import (
+	"context"
+	"github.com/crgimenes/go-osc"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingSender keeps every packet instead of sending it.
+type recordingSender struct {
+	mu      sync.Mutex
+	packets []osc.Packet
+}
+
+func (s *recordingSender) Send(packet osc.Packet) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.packets = append(s.packets, packet)
+	return nil
+}
+
+// messages returns the messages sent so far, the ones inside bundles in
+// bundle order.
+func (s *recordingSender) messages() []*osc.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []*osc.Message
+	for _, packet := range s.packets {
+		switch p := packet.(type) {
+		case *osc.Message:
+			out = append(out, p)
+		case *osc.Bundle:
+			out = append(out, p.Messages...)
+		}
+	}
+	return out
+}
+
+// newTestForwarder builds a forwarder for cfg whose destinations, cfg's own
+// ones, record instead of sending.
+func newTestForwarder(cfg *Config) (*forwarder, []*recordingSender) {
+	dests := newDestinations(cfg.AllDestinations(), true, newRetryPolicy(cfg), newBreaker(cfg))
+	senders := make([]*recordingSender, len(dests))
+	for i, dest := range dests {
+		senders[i] = &recordingSender{}
+		dest.sender = senders[i]
+	}
+	return newForwarder(dests, cfg), senders
+}
+
+// testConfig is the default config with a single destination and no extra
+// ones.
+func testConfig() *Config {
+	cfg := DefaultConfig()
+	cfg.Destinations = nil
+	return cfg
+}
+
+// runForwarder feeds updates through f and returns once it has finished
+// with all of them.
+func runForwarder(f *forwarder, updates ...TrackerData) {
+	ch := make(chan TrackerData, len(updates))
+	for _, data := range updates {
+		ch <- data
+	}
+	close(ch)
+	f.forwardUpdatedData(context.Background(), ch)
+}
+
+func position(id int, x, y, z float32) TrackerData {
+	return TrackerData{ID: id, Position: [3]float32{x, y, z}, Fields: FieldPosition}
+}
+
+func TestForwardPositionAndRotation(t *testing.T) {
+	f, senders := newTestForwarder(testConfig())
+	data := position(7, 1.5, -2, 3.25)
+	data.Rotation, data.Fields = [3]float32{10, -20, 179.5}, data.Fields|FieldRotation
+
+	runForwarder(f, data)
+
+	msgs := senders[0].messages()
+	if len(msgs) != 2 {
+		t.Fatalf("sent %d messages, want 2: %v", len(msgs), msgs)
+	}
+	for i, want := range []struct {
+		address string
+		args    []any
+	}{
+		{"/tracking/trackers/7/position", []any{float32(1.5), float32(-2), float32(3.25)}},
+		{"/tracking/trackers/7/rotation", []any{float32(10), float32(-20), float32(179.5)}},
+	} {
+		if msgs[i].Address != want.address || !reflect.DeepEqual([]any(msgs[i].Arguments), want.args) {
+			t.Errorf("message %d is %v %v, want %s %v", i, msgs[i].Address, msgs[i].Arguments, want.address, want.args)
+		}
+	}
+}
+
+func TestBundlesSplitAtMaxSize(t *testing.T) {
+	cfg := testConfig()
+	cfg.BundleWindow = time.Hour // flushed by hand
+	cfg.BundleMaxSize = 200
+	f, senders := newTestForwarder(cfg)
+	for id := 1; id <= 10; id++ {
+		f.forward(position(id, float32(id), 0, 0))
+	}
+	f.flush()
+
+	var ids []int32
+	for _, packet := range senders[0].packets {
+		bundle, ok := packet.(*osc.Bundle)
+		if !ok {
+			t.Fatalf("sent %T, want bundles", packet)
+		}
+		b, err := bundle.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(b) > cfg.BundleMaxSize {
+			t.Errorf("bundle of %d bytes, bigger than %d", len(b), cfg.BundleMaxSize)
+		}
+		for _, msg := range bundle.Messages {
+			ids = append(ids, int32(msg.Arguments[0].(float32)))
+		}
+	}
+	if len(senders[0].packets) < 2 {
+		t.Errorf("sent %d bundles, want the messages split over several", len(senders[0].packets))
+	}
+	if len(ids) != 10 {
+		t.Fatalf("sent %d messages in bundles, want 10", len(ids))
+	}
+	for i, id := range ids {
+		if id != int32(i+1) {
+			t.Errorf("message %d is of tracker %d, the order changed", i, id)
+		}
+	}
+}
+
+func TestOversizedMessageGetsItsOwnBundle(t *testing.T) {
+	cfg := testConfig()
+	cfg.BundleWindow = time.Hour
+	cfg.BundleMaxSize = minBundleMaxSize
+	f, senders := newTestForwarder(cfg)
+	f.send(osc.NewMessage("/small", float32(1)), FieldPosition, 1, "position", &TrackerData{ID: 1})
+	f.send(osc.NewMessage("/big", make([]byte, 2*minBundleMaxSize)), FieldPosition, 2, "position", &TrackerData{ID: 2})
+	f.flush()
+
+	if n := len(senders[0].packets); n != 2 {
+		t.Fatalf("sent %d bundles, want 2", n)
+	}
+	for i, want := range []string{"/small", "/big"} {
+		bundle := senders[0].packets[i].(*osc.Bundle)
+		if len(bundle.Messages) != 1 || bundle.Messages[0].Address != want {
+			t.Errorf("bundle %d holds %v, want only %s", i, bundle.Messages, want)
+		}
+	}
+}
+
+func TestPredictIgnoresOldVelocity(t *testing.T) {
+	cfg := testConfig()
+	cfg.PredictLead = 100 * time.Millisecond
+	cfg.PredictMaxAge = 50 * time.Millisecond
+	f, _ := newTestForwarder(cfg)
+	now := time.Now()
+
+	moving := position(1, 0, 0, 0)
+	moving.Velocity, moving.Fields = [3]float32{10, 0, 0}, moving.Fields|FieldVelocity
+	moving.LastSeen, moving.VelocityTime = now.Add(-time.Second), now.Add(-time.Second)
+	f.predict(&moving)
+
+	fresh := position(1, 5, 0, 0)
+	fresh.LastSeen = now
+	f.predict(&fresh)
+	if fresh.Position[0] != 5 {
+		t.Errorf("projected along a velocity a second old to x=%v, want 5", fresh.Position[0])
+	}
+
+	moving.LastSeen, moving.VelocityTime = now.Add(-10*time.Millisecond), now.Add(-10*time.Millisecond)
+	f.predict(&moving)
+	fresh.Position[0] = 5
+	f.predict(&fresh)
+	if fresh.Position[0] != 6 {
+		t.Errorf("projected to x=%v, want 6 along a recent velocity", fresh.Position[0])
+	}
+}
+
+func TestPredictCoalescedKeepsVelocityAge(t *testing.T) {
+	cfg := testConfig()
+	cfg.MaxRate = 1 // forwarded by hand
+	cfg.PredictLead = 100 * time.Millisecond
+	cfg.PredictMaxAge = 50 * time.Millisecond
+	f, senders := newTestForwarder(cfg)
+	now := time.Now()
+
+	moving := position(1, 5, 0, 0)
+	moving.Velocity, moving.Fields = [3]float32{10, 0, 0}, moving.Fields|FieldVelocity
+	moving.LastSeen, moving.VelocityTime = now.Add(-time.Second), now.Add(-time.Second)
+	f.coalesce(moving)
+	// a fresh rotation makes the coalesced update look current, its
+	// velocity is still a second old
+	turned := rotation(1, 0, 90, 0)
+	turned.LastSeen = now
+	f.coalesce(turned)
+	f.forwardCoalesced()
+
+	sent := false
+	for _, msg := range senders[0].messages() {
+		if strings.HasSuffix(msg.Address, "/position") {
+			sent = true
+			if msg.Arguments[0] != float32(5) {
+				t.Errorf("position forwarded as %v, want x=5 without projecting a stale velocity", msg.Arguments)
+			}
+		}
+	}
+	if !sent {
+		t.Error("coalesced position not forwarded")
+	}
+}
+
+// addresses returns the addresses of msgs.
+func addresses(msgs []*osc.Message) []string {
+	out := make([]string, len(msgs))
+	for i, msg := range msgs {
+		out[i] = msg.Address
+	}
+	return out
+}
+
+func TestDestinationFilters(t *testing.T) {
+	cfg := testConfig()
+	cfg.DestFields = []string{"position"}
+	cfg.Destinations = []Destination{{Host: "127.0.0.1", Port: 9100, IDs: []int{2}}}
+	f, senders := newTestForwarder(cfg)
+	var updates []TrackerData
+	for id := 1; id <= 2; id++ {
+		data := position(id, 1, 2, 3)
+		data.Rotation, data.Fields = [3]float32{10, 20, 30}, data.Fields|FieldRotation
+		updates = append(updates, data)
+	}
+
+	runForwarder(f, updates...)
+
+	for i, want := range [][]string{
+		{"/tracking/trackers/1/position", "/tracking/trackers/2/position"},
+		{"/tracking/trackers/2/position", "/tracking/trackers/2/rotation"},
+	} {
+		if got := addresses(senders[i].messages()); !slices.Equal(got, want) {
+			t.Errorf("destination %d got %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestForwarderWithoutDestinations(t *testing.T) {
+	total := func() (n uint64) {
+		_, sent := metrics.Forwarded.snapshot()
+		for _, v := range sent {
+			n += v
+		}
+		return n
+	}
+	before := total()
+	for _, tweak := range []func(cfg *Config){
+		func(cfg *Config) {},
+		func(cfg *Config) { cfg.BundleWindow = time.Millisecond },
+		func(cfg *Config) { cfg.MaxRate = 1000 },
+	} {
+		cfg := testConfig()
+		cfg.DestHost = ""
+		tweak(cfg)
+		f, senders := newTestForwarder(cfg)
+		if len(senders) != 0 {
+			t.Fatalf("%d destinations, want none", len(senders))
+		}
+		f.Passthrough(osc.NewMessage("/other"))
+		update := position(1, 1, 2, 3)
+		update.LastSeen = time.Now()
+		runForwarder(f, update, TrackerData{ID: 1, Removed: true})
+		f.flush()
+	}
+	if sent := total() - before; sent != 0 {
+		t.Errorf("forwarded %d packets without destinations", sent)
+	}
+}
+
+func TestForwardEncoding(t *testing.T) {
+	cfg := testConfig()
+	cfg.DestEncoding = map[string]string{"position": EncodingFloat64}
+	f, senders := newTestForwarder(cfg)
+	data := position(1, 1.5, 2, 3)
+	data.Rotation, data.Fields = [3]float32{10, 20, 30}, data.Fields|FieldRotation
+
+	runForwarder(f, data)
+
+	msgs := senders[0].messages()
+	if len(msgs) != 2 {
+		t.Fatalf("sent %d messages, want 2", len(msgs))
+	}
+	for i, want := range []string{"ddd", "fff"} {
+		if got := typeTags(msgs[i].Arguments); got != want {
+			t.Errorf("%s sent with type tags %s, want %s", msgs[i].Address, got, want)
+		}
+	}
+	if x := msgs[0].Arguments[0]; x != float64(1.5) {
+		t.Errorf("position x sent as %#v, want float64(1.5)", x)
+	}
+}
+
+func TestForwarderForgetsRemovedTrackers(t *testing.T) {
+	cfg := testConfig()
+	cfg.PredictLead = 0
+	f, senders := newTestForwarder(cfg)
+	update := position(1, 1, 2, 3)
+	update.Velocity, update.Fields = [3]float32{1, 0, 0}, update.Fields|FieldVelocity
+
+	runForwarder(f, update, TrackerData{ID: 1, Removed: true}, update)
+
+	// the same values again are only sent because the first send was forgotten
+	if got := len(senders[0].messages()); got != 4 {
+		t.Errorf("sent %d messages, want 4 (position and velocity twice)", got)
+	}
+	runForwarder(f, TrackerData{ID: 1, Removed: true})
+	if len(f.last) != 0 || len(f.velocities) != 0 {
+		t.Errorf("forwarder still keeps %d last values and %d velocities", len(f.last), len(f.velocities))
+	}
+}
+
+func TestForwarderDoesNotPredictAlongVelocityOfRemovedTracker(t *testing.T) {
+	cfg := testConfig()
+	cfg.PredictLead = 100 * time.Millisecond
+	f, senders := newTestForwarder(cfg)
+	moving := position(1, 0, 0, 0)
+	moving.Velocity, moving.Fields = [3]float32{10, 0, 0}, moving.Fields|FieldVelocity
+	back := position(1, 5, 0, 0)
+	moving.LastSeen = time.Now()
+	back.LastSeen = time.Now()
+
+	runForwarder(f, moving, TrackerData{ID: 1, Removed: true}, back)
+
+	msgs := senders[0].messages()
+	last := msgs[len(msgs)-1]
+	if x := last.Arguments[0].(float32); x != 5 {
+		t.Errorf("returning tracker forwarded at x=%v, want 5 without a stale projection", x)
+	}
+}
+
+func TestQuantize(t *testing.T) {
+	for _, c := range []struct {
+		in   [3]float32
+		step float64
+		want [3]float32
+	}{
+		{[3]float32{1.234, -1.236, 0.004}, 0.01, [3]float32{1.23, -1.24, 0}},
+		{[3]float32{12, 17, -23}, 5, [3]float32{10, 15, -25}},
+		{[3]float32{1.234, 5.678, 9}, 0, [3]float32{1.234, 5.678, 9}},
+	} {
+		got := quantize(c.in, c.step)
+		for i := range got {
+			if math.Abs(float64(got[i]-c.want[i])) > 1e-6 {
+				t.Errorf("quantize(%v, %v) = %v, want %v", c.in, c.step, got, c.want)
+				break
+			}
+		}
+	}
+}
+
+func TestRoundingKeepsVelocityPrecise(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Velocity = true
+	cfg.VelocityMinInterval = 0
+	cfg.PositionStep = 1
+	tm := newTestManager(t, cfg)
+	start := time.Now()
+	for i, x := range []float32{0.2, 0.4} {
+		data := position(1, x, 0, 0)
+		data.SourceTime = start.Add(time.Duration(i) * time.Second)
+		tm.process(data)
+	}
+	updates := forwarded(tm)
+	if v := updates[len(updates)-1].Velocity[0]; math.Abs(float64(v)-0.2) > 1e-5 {
+		t.Errorf("velocity %v, want 0.2 from the unrounded positions", v)
+	}
+	if stored, _ := tm.GetTrackerData("", 1); stored.Position[0] != 0.4 {
+		t.Errorf("stored x=%v, want the unrounded 0.4", stored.Position[0])
+	}
+
+	cfg.Destinations = nil
+	f, senders := newTestForwarder(cfg)
+	runForwarder(f, updates...)
+	msgs := senders[0].messages()
+	if len(msgs) == 0 || msgs[0].Address != "/tracking/trackers/1/position" {
+		t.Fatalf("sent %v, want the position first", addresses(msgs))
+	}
+	if x := msgs[0].Arguments[0]; x != float32(0) {
+		t.Errorf("forwarded x=%v, want it rounded to 0", x)
+	}
+}
@@ -4,4 +4,7 @@ go 1.23
 
 toolchain go1.23.0
 
-require github.com/crgimenes/go-osc v0.0.0-20240814180712-2246a079e75a
+require (
+	github.com/crgimenes/go-osc v0.0.0-20240814180712-2246a079e75a
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -0,0 +1,34 @@
+package main
+
+// history keeps the last updates of a tracker in a fixed size ring. Once
+// full, the oldest entry is overwritten in place so recording does not
+// allocate.
+type history struct {
+	samples []TrackerData
+	next    int  // index the next sample is written to
+	full    bool // samples has wrapped around at least once
+}
+
+// add records data, keeping at most capacity samples. A changed capacity
+// starts the ring over.
+func (h *history) add(data TrackerData, capacity int) {
+	if len(h.samples) != capacity {
+		*h = history{samples: make([]TrackerData, capacity)}
+	}
+	h.samples[h.next] = data
+	h.next++
+	if h.next == len(h.samples) {
+		h.next = 0
+		h.full = true
+	}
+}
+
+// copy returns the recorded samples, oldest first.
+func (h *history) copy() []TrackerData {
+	if !h.full {
+		return append([]TrackerData{}, h.samples[:h.next]...)
+	}
+	out := make([]TrackerData, 0, len(h.samples))
+	out = append(out, h.samples[h.next:]...)
+	return append(out, h.samples[:h.next]...)
+}
@@ -0,0 +1,101 @@
+package main
+
+import "time"
+
+// jitterMemory is how long the source time of the last released update of a
+// tracker is kept to spot late packets. Reordering happens within
+// milliseconds, anything older is more likely a sender that restarted with a
+// different clock, whose updates should not all be dropped as late.
+const jitterMemory = time.Second
+
+// jitterBuffer holds updates that carry a source time for a short window and
+// releases them per tracker in source time order, so packets that UDP
+// delivered out of order are processed in the order they were sent. It is
+// only used from processUpdates and so needs no locking.
+type jitterBuffer struct {
+	held     map[trackerKey][]heldUpdate // sorted by source time
+	released map[trackerKey]releasedAt   // last update released per tracker
+}
+
+type heldUpdate struct {
+	data TrackerData
+	due  time.Time // arrival plus the window
+}
+
+type releasedAt struct {
+	sourceTime time.Time
+	at         time.Time
+}
+
+func newJitterBuffer() *jitterBuffer {
+	return &jitterBuffer{
+		held:     make(map[trackerKey][]heldUpdate),
+		released: make(map[trackerKey]releasedAt),
+	}
+}
+
+// add holds data until now+window. It returns false, and drops data, when a
+// newer update of the same tracker was already released.
+func (b *jitterBuffer) add(data TrackerData, now time.Time, window time.Duration) bool {
+	key := data.key()
+	if last, exists := b.released[key]; exists && now.Sub(last.at) < jitterMemory && data.SourceTime.Before(last.sourceTime) {
+		return false
+	}
+
+	held := b.held[key]
+	i := len(held)
+	for i > 0 && data.SourceTime.Before(held[i-1].data.SourceTime) {
+		i--
+	}
+	held = append(held, heldUpdate{})
+	copy(held[i+1:], held[i:])
+	held[i] = heldUpdate{data: data, due: now.Add(window)}
+	b.held[key] = held
+	return true
+}
+
+// release returns the updates that are due at now in source time order per
+// tracker, together with the older ones held for the same tracker, which
+// would be late after them. With all set everything is released.
+func (b *jitterBuffer) release(now time.Time, all bool) []TrackerData {
+	var out []TrackerData
+	for key, held := range b.held {
+		n := 0
+		for i, h := range held {
+			if all || !h.due.After(now) {
+				n = i + 1
+			}
+		}
+		if n == 0 {
+			continue
+		}
+		for _, h := range held[:n] {
+			out = append(out, h.data)
+		}
+		b.released[key] = releasedAt{sourceTime: held[n-1].data.SourceTime, at: now}
+		if n == len(held) {
+			delete(b.held, key)
+		} else {
+			b.held[key] = held[n:]
+		}
+	}
+	for key, last := range b.released {
+		if now.Sub(last.at) >= jitterMemory {
+			delete(b.released, key)
+		}
+	}
+	return out
+}
+
+// nextDue returns when the next held update is due, ok is false when nothing
+// is held.
+func (b *jitterBuffer) nextDue() (due time.Time, ok bool) {
+	for _, held := range b.held {
+		for _, h := range held {
+			if !ok || h.due.Before(due) {
+				due, ok = h.due, true
+			}
+		}
+	}
+	return due, ok
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/crgimenes/go-osc"
+	"io"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// messageHandler receives every incoming message. sourceTime is the timetag
+// of the bundle the message came in, zero for bare messages. from is the
+// sender, nil when replaying.
+type messageHandler func(msg *osc.Message, sourceTime time.Time, from net.Addr)
+
+// dispatcher unpacks bundles and passes each message to handle together with
+// the bundle timetag. Unlike osc.StandardDispatcher it does not hold bundles
+// back until their timetag, the timetag is only used as the source timestamp.
+type dispatcher struct {
+	handle messageHandler
+}
+
+func (d *dispatcher) Dispatch(packet osc.Packet, addr net.Addr) error {
+	switch p := packet.(type) {
+	case *osc.Message:
+		d.handle(p, time.Time{}, addr)
+	case *osc.Bundle:
+		var sourceTime time.Time
+		if p.Timetag > 1 { // 1 means "immediately" and carries no time
+			sourceTime = timetagTime(p.Timetag)
+		}
+		d.dispatchBundle(p, sourceTime, addr)
+	default:
+		return osc.ErrorUnsuportedPackage
+	}
+	return nil
+}
+
+// ntpEpochOffset is the number of seconds from 1900, where timetags count
+// from, to the unix epoch
+const ntpEpochOffset = 2208988800
+
+// timetagTime converts t to a time. osc.Timetag.Time reads the fraction as
+// nanoseconds, but senders following the spec send it in units of 2^-32
+// seconds, which would put the time up to 4s off.
+func timetagTime(t osc.Timetag) time.Time {
+	frac := (uint64(t) & 0xffffffff) * uint64(time.Second) >> 32
+	return time.Unix(int64(t>>32)-ntpEpochOffset, int64(frac))
+}
+
+// dispatchBundle hands on the messages of b and its nested bundles, all with
+// the timetag of the outermost bundle. go-osc loses the fraction of nested
+// timetags, and the spec has them no earlier than the enclosing one anyway.
+func (d *dispatcher) dispatchBundle(b *osc.Bundle, sourceTime time.Time, from net.Addr) {
+	for _, msg := range b.Messages {
+		d.handle(msg, sourceTime, from)
+	}
+	for _, nested := range b.Bundles {
+		d.dispatchBundle(nested, sourceTime, from)
+	}
+}
+
+// peer is the sender of a packet, replies go back over the connection the
+// packet arrived on.
+type peer struct {
+	net.Addr
+	conn net.PacketConn
+}
+
+func (p *peer) reply(packet osc.Packet) error {
+	data, err := packet.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	_, err = p.conn.WriteTo(data, p.Addr)
+	return err
+}
+
+// startListener listens on addr and serves it with d in the background. The
+// error ending a serve loop is sent on serverErr, closing the returned
+// listener stops it.
+//
+// A wildcard address ("" or "::" as host) accepts the families selected by
+// family. For dual, Go opens one dual-stack socket, which some systems
+// (OpenBSD, or Windows setups without IPv4-mapped addresses) do not support.
+// There the IPv6 socket only sees IPv6, so a second socket for IPv4 is
+// opened on the same port. Where dual-stack works that second bind fails
+// because the port is taken, which is how the two cases are told apart.
+func startListener(addr, transport, family string, d osc.Dispatcher, serverErr chan<- error) (io.Closer, error) {
+	if transport == TransportUnix {
+		ln, _, err := serveListener("unixgram", addr, transport, d, serverErr)
+		return ln, err
+	}
+	network := transport
+	switch family {
+	case FamilyIPv4:
+		network += "4"
+	case FamilyIPv6:
+		network += "6"
+	}
+	ln, local, err := serveListener(network, addr, transport, d, serverErr)
+	if err != nil {
+		return nil, err
+	}
+	otherNetwork, otherAddr := otherFamily(addr, transport, family, local)
+	if otherAddr == "" {
+		return ln, nil
+	}
+	other, _, err := serveListener(otherNetwork, otherAddr, transport, d, serverErr)
+	if err != nil {
+		slog.Debug("Dual-stack listener covers both families", "addr", local.String())
+		return ln, nil
+	}
+	return listeners{ln, other}, nil
+}
+
+// serveListener opens one socket of network on addr and serves it.
+func serveListener(network, addr, transport string, d osc.Dispatcher, serverErr chan<- error) (io.Closer, net.Addr, error) {
+	if transport == TransportTCP {
+		ln, err := listenStream(network, addr)
+		if err != nil {
+			return nil, nil, err
+		}
+		go func() {
+			slog.Info("Starting listener", "addr", ln.Addr().String(), "transport", TransportTCP)
+			health.Listening.Store(true)
+			err := ln.serve(d)
+			health.Listening.Store(false)
+			serverErr <- err
+		}()
+		return ln, ln.Addr(), nil
+	}
+
+	var conn net.PacketConn
+	var err error
+	if transport == TransportUnix {
+		conn, err = listenUnix(addr)
+	} else {
+		conn, err = net.ListenPacket(network, addr)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	go func() {
+		slog.Info("Starting listener", "addr", conn.LocalAddr().String(), "transport", transportName(transport))
+		health.Listening.Store(true)
+		err := serveOSC(conn, d)
+		health.Listening.Store(false)
+		serverErr <- err
+	}()
+	return conn, conn.LocalAddr(), nil
+}
+
+// otherFamily returns the network and wildcard address of the family the
+// socket bound to local does not cover, on the same port, when addr asked
+// for both. addr is "" otherwise.
+func otherFamily(addr, transport, family string, local net.Addr) (network, other string) {
+	host, _, err := net.SplitHostPort(addr)
+	if family != FamilyDual || err != nil || (host != "" && host != "::") {
+		return "", ""
+	}
+	localHost, port, err := net.SplitHostPort(local.String())
+	if err != nil {
+		return "", ""
+	}
+	if ip := net.ParseIP(localHost); ip != nil && ip.To4() != nil {
+		return transport + "6", net.JoinHostPort("::", port)
+	}
+	return transport + "4", net.JoinHostPort("0.0.0.0", port)
+}
+
+// listeners closes several listeners as one.
+type listeners []io.Closer
+
+func (l listeners) Close() error {
+	var errs []error
+	for _, ln := range l {
+		errs = append(errs, ln.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// serveOSC reads OSC packets from conn and dispatches them until conn is
+// closed. Unlike osc.Server it keeps going after a malformed packet.
+func serveOSC(conn net.PacketConn, d osc.Dispatcher) error {
+	reader := &osc.Server{} // only used for its packet decoding
+	raw := &rawConn{PacketConn: conn}
+	for {
+		packet, addr, err := reader.Read(raw)
+		if err != nil {
+			if raw.err == nil {
+				// the read worked but the packet did not decode
+				slog.Debug("Dropping malformed packet", "from", senderName(addr), "err", err)
+				continue
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		if b, ok := packet.(*osc.Bundle); ok && len(raw.last) >= 16 {
+			// decoding already mangled the timetag fraction, see timetagTime
+			b.Timetag = osc.Timetag(binary.BigEndian.Uint64(raw.last[8:16]))
+		}
+		var from net.Addr
+		if addr != nil {
+			from = &peer{Addr: addr, conn: conn}
+		}
+		if err := d.Dispatch(packet, from); err != nil {
+			slog.Debug("Dispatch failed", "from", senderName(addr), "err", err)
+		}
+	}
+}
+
+// senderName is addr for logging, a Unix datagram socket sending without
+// binding one has no address.
+func senderName(addr net.Addr) string {
+	if addr == nil {
+		return "unnamed"
+	}
+	return addr.String()
+}
+
+// rawConn keeps the last packet read from the wrapped conn, and the error
+// of that read to tell it from a decoding error.
+type rawConn struct {
+	net.PacketConn
+	last []byte
+	err  error
+}
+
+func (c *rawConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(p)
+	c.last, c.err = p[:n], err
+	return n, addr, err
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/crgimenes/go-osc"
+	"net"
+	"testing"
+	"time"
+)
+
+// oscSink is a destination on an ephemeral loopback port collecting every
+// message it receives.
+type oscSink struct {
+	conn net.PacketConn
+	msgs chan *osc.Message
+}
+
+func newOSCSink(t *testing.T) *oscSink {
+	t.Helper()
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &oscSink{conn: conn, msgs: make(chan *osc.Message, 100)}
+	d := &dispatcher{handle: func(msg *osc.Message, _ time.Time, _ net.Addr) { s.msgs <- msg }}
+	go serveOSC(conn, d)
+	t.Cleanup(func() { conn.Close() })
+	return s
+}
+
+func (s *oscSink) port() int {
+	return s.conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+// receive waits for the next n messages.
+func (s *oscSink) receive(t *testing.T, n int) []*osc.Message {
+	t.Helper()
+	var out []*osc.Message
+	for len(out) < n {
+		select {
+		case msg := <-s.msgs:
+			out = append(out, msg)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("received %d messages, want %d", len(out), n)
+		}
+	}
+	return out
+}
+
+func TestLoopback(t *testing.T) {
+	sink := newOSCSink(t)
+	cfg := DefaultConfig()
+	cfg.DestHost, cfg.DestPort = "127.0.0.1", sink.port()
+	cfg.ListenAddr = "127.0.0.1:0"
+
+	tm := NewTrackerManager(cfg.UpdateBufferSize, cfg.ForwardBufferSize)
+	tm.Configure(cfg)
+	f := newForwarder(newDestinations(cfg.AllDestinations(), false, newRetryPolicy(cfg), newBreaker(cfg)), cfg)
+	forwardDone := make(chan struct{})
+	go func() {
+		f.forwardUpdatedData(context.Background(), tm.forwardCh)
+		close(forwardDone)
+	}()
+
+	serverErr := make(chan error, 1)
+	d := &dispatcher{handle: func(msg *osc.Message, sourceTime time.Time, from net.Addr) {
+		tm.HandleMessage(msg, sourceTime, from)
+	}}
+	ln, err := startListener(cfg.ListenAddr, cfg.ListenTransport, FamilyIPv4, d, serverErr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := osc.NewClient("127.0.0.1", ln.(net.PacketConn).LocalAddr().(*net.UDPAddr).Port)
+
+	// one at a time, so the order on the wire is the order sent
+	send := func(address string, args ...any) *osc.Message {
+		t.Helper()
+		if err := client.Send(osc.NewMessage(address, args...)); err != nil {
+			t.Fatal(err)
+		}
+		return sink.receive(t, 1)[0]
+	}
+	position := send("/tracking/trackers/1/position", float32(1), float32(2), float32(3))
+	rotation := send("/tracking/trackers/1/rotation", float32(10), float32(20), float32(30))
+	// Y jumps 180 degrees, an inversion
+	corrected := send("/tracking/trackers/1/rotation", float32(10), float32(-160), float32(30))
+
+	for _, c := range []struct {
+		msg     *osc.Message
+		address string
+		want    [3]float32
+	}{
+		{position, "/tracking/trackers/1/position", [3]float32{1, 2, 3}},
+		{rotation, "/tracking/trackers/1/rotation", [3]float32{10, 20, 30}},
+		{corrected, "/tracking/trackers/1/rotation", [3]float32{-170, 20, -150}},
+	} {
+		if c.msg.Address != c.address || len(c.msg.Arguments) != 3 {
+			t.Errorf("forwarded %v, want %s with 3 arguments", c.msg, c.address)
+			continue
+		}
+		for i, want := range c.want {
+			if got := c.msg.Arguments[i].(float32); got != want {
+				t.Errorf("%s argument %d = %v, want %v", c.address, i, got, want)
+			}
+		}
+	}
+	tracker, exists := tm.GetTrackerData("", 1)
+	if !exists {
+		t.Fatal("tracker 1 is not tracked")
+	}
+	if tracker.Position != [3]float32{1, 2, 3} || tracker.Rotation != [3]float32{-170, 20, -150} {
+		t.Errorf("tracker 1 at %v rotated %v, want the corrected rotation", tracker.Position, tracker.Rotation)
+	}
+
+	ln.Close()
+	if err := <-serverErr; !errors.Is(err, net.ErrClosed) {
+		t.Errorf("listener ended with %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if drained, dropped := tm.Shutdown(ctx); dropped != 0 {
+		t.Errorf("Shutdown drained %d and dropped %d updates", drained, dropped)
+	}
+	select {
+	case <-forwardDone:
+	case <-time.After(time.Second):
+		t.Fatal("forwarder did not finish")
+	}
+}
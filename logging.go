@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// logLevel is shared by every handler so the level can be changed at runtime.
+var logLevel = new(slog.LevelVar)
+
+func init() {
+	setupLogging("text")
+}
+
+// setupLogging installs the default logger writing to stdout, format is
+// "text" or "json".
+func setupLogging(format string) {
+	opts := &slog.HandlerOptions{
+		AddSource:   true,
+		Level:       logLevel,
+		ReplaceAttr: shortSource,
+	}
+	var handler slog.Handler
+	if format == "json" {
+		handler = slog.NewJSONHandler(os.Stdout, opts)
+	} else {
+		handler = slog.NewTextHandler(os.Stdout, opts)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+// shortSource trims the source attribute down to file:line.
+func shortSource(groups []string, a slog.Attr) slog.Attr {
+	if src, ok := a.Value.Any().(*slog.Source); ok && a.Key == slog.SourceKey {
+		return slog.String(slog.SourceKey, filepath.Base(src.File)+":"+strconv.Itoa(src.Line))
+	}
+	return a
+}
+
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(s))
+	return level, err
+}
+
+// errorLog collapses repeated errors on hot paths, see logThrottle
+var errorLog = &logThrottle{repeats: make(map[string]*logRepeat)}
+
+// logThrottle writes the first of a run of identical log lines right away
+// and folds the repeats within the window into one line with a count, e.g.
+// "Error sending position (x1423 in last 1s)". A window of 0 logs every line.
+type logThrottle struct {
+	mu      sync.Mutex
+	window  time.Duration
+	repeats map[string]*logRepeat
+}
+
+// logRepeat is a line that was written and is now being counted
+type logRepeat struct {
+	level slog.Level
+	pc    uintptr // caller of the last repeat, for the source
+	msg   string
+	attrs []any     // of the last repeat
+	count int       // repeats since the line was last written
+	since time.Time // when it was last written
+}
+
+// SetWindow changes the window, lines already being counted keep theirs.
+func (t *logThrottle) SetWindow(window time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.window = window
+}
+
+// Warn logs msg with attrs at warn level unless a line with the same key was
+// written within the window. key tells lines apart that share msg, e.g. the
+// destination and error, while attrs may vary between repeats.
+func (t *logThrottle) Warn(key, msg string, attrs ...any) {
+	if !slog.Default().Enabled(context.Background(), slog.LevelWarn) {
+		return
+	}
+	var pcs [1]uintptr
+	runtime.Callers(2, pcs[:])
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key = msg + "\x00" + key
+	if r, exists := t.repeats[key]; exists {
+		r.pc, r.attrs = pcs[0], attrs
+		r.count++
+		return
+	}
+	writeLog(slog.LevelWarn, pcs[0], msg, attrs)
+	if t.window <= 0 {
+		return
+	}
+	t.repeats[key] = &logRepeat{level: slog.LevelWarn, pc: pcs[0], msg: msg, attrs: attrs, since: time.Now()}
+	time.AfterFunc(t.window, func() { t.flush(key) })
+}
+
+// flush writes the count of the repeats of key once its window is over, and
+// starts another window for them. A window without repeats ends the run, the
+// next line is written right away again.
+func (t *logThrottle) flush(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	r := t.repeats[key]
+	if r.count == 0 {
+		delete(t.repeats, key)
+		return
+	}
+	now := time.Now()
+	writeLog(r.level, r.pc, fmt.Sprintf("%s (x%d in last %s)", r.msg, r.count, now.Sub(r.since).Round(time.Millisecond)), r.attrs)
+	r.count, r.since = 0, now
+	time.AfterFunc(max(t.window, time.Millisecond), func() { t.flush(key) })
+}
+
+// writeLog writes a record to the default logger attributed to pc.
+func writeLog(level slog.Level, pc uintptr, msg string, attrs []any) {
+	record := slog.NewRecord(time.Now(), level, msg, pc)
+	record.Add(attrs...)
+	slog.Default().Handler().Handle(context.Background(), record)
+}
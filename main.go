@@ -1,195 +1,1419 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"github.com/crgimenes/go-osc"
-	"log"
+	"io"
+	"log/slog"
 	"math"
+	"net"
+	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
-func init() {
-	log.SetOutput(os.Stdout)
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
-}
+// Field flags, set on TrackerData.Fields to mark which values an update carries
+const (
+	FieldPosition uint8 = 1 << iota
+	FieldRotation
+	FieldQuaternion
+	FieldVelocity
+	FieldActive // the update is a status message, see TrackerData.Inactive
+)
 
 type TrackerData struct {
-	ID       int
-	Position [3]float32
-	Rotation [3]float32
+	ID       int        `json:"id"`
+	Source   string     `json:"source,omitempty"` // namespace of the sender, empty unless source_namespace is on
+	Position [3]float32 `json:"position"`
+	Rotation [3]float32 `json:"rotation"`
+	Fields   uint8      `json:"-"` // which of Position/Rotation/Quaternion are set, see Field* flags
+	LastSeen time.Time  `json:"last_seen"`
+
+	Quaternion [4]float32 `json:"quaternion"` // x,y,z,w, for sources that send rotation as a quaternion
+	Velocity   [3]float32 `json:"velocity"`   // units per second, derived from position when enabled
+
+	VelocityTime time.Time `json:"-"` // arrival of the update Velocity was derived from, it may be older than LastSeen
+
+	SourceTime time.Time `json:"source_time"`    // timetag of the bundle the update arrived in, zero if none
+	From       string    `json:"from,omitempty"` // address of the last sender, empty when replaying
+	Rate       float64   `json:"rate"`           // incoming updates per second, only set on stored trackers
+	Frozen     bool      `json:"frozen"`         // still sending but the values stopped changing, only set on stored trackers
+	Inactive   bool      `json:"inactive"`       // the last status message of the sender reported the tracker as not tracking
+	Disabled   bool      `json:"disabled"`       // switched off at runtime, tracked but not forwarded
+
+	Removed bool `json:"-"` // the tracker expired or was evicted, a notice carrying no values, see TrackerManager.removals
+}
+
+// trackerKey identifies a tracker, the same ID from different sources are
+// different trackers.
+type trackerKey struct {
+	source string
+	id     int
+}
+
+func (t *TrackerData) key() trackerKey {
+	return trackerKey{source: t.Source, id: t.ID}
+}
+
+// merge copies the fields update carries into t, leaving the others alone.
+func (t *TrackerData) merge(update TrackerData) {
+	if update.Fields&FieldPosition != 0 {
+		t.Position = update.Position
+	}
+	if update.Fields&FieldRotation != 0 {
+		t.Rotation = update.Rotation
+	}
+	if update.Fields&FieldQuaternion != 0 {
+		t.Quaternion = update.Quaternion
+	}
+	if update.Fields&FieldVelocity != 0 {
+		t.Velocity = update.Velocity
+		t.VelocityTime = update.VelocityTime
+	}
+	if update.Fields&FieldActive != 0 {
+		t.Inactive = update.Inactive
+	}
+	t.Disabled = update.Disabled // every update carries the current switch
+	t.Fields |= update.Fields
+	t.LastSeen = update.LastSeen
+	t.SourceTime = update.SourceTime
+	t.From = update.From
+}
+
+// logAttrs identifies the tracker in log lines.
+func (t *TrackerData) logAttrs() []any {
+	if t.Source != "" {
+		return []any{"tracker", t.ID, "source", t.Source}
+	}
+	return []any{"tracker", t.ID}
+}
+
+// timestamp is the source time of the update if known, otherwise arrival.
+func (t *TrackerData) timestamp(arrival time.Time) time.Time {
+	if !t.SourceTime.IsZero() {
+		return t.SourceTime
+	}
+	return arrival
+}
+
+// trackerState is per tracker processing state that is not part of TrackerData.
+type trackerState struct {
+	smooth          smoothState
+	smoothingFactor float64 // resolved from smoothingFactors when the tracker appears, 0 disables
+
+	prevPosition [3]float32 // last position used for velocity
+	prevTime     time.Time  // when prevPosition was seen, zero before the first sample
+
+	rotationInversions   int // consecutive samples detected as inverted
+	quaternionInversions int
+
+	lastArrival time.Time // of the previous update, for the rate
+	interval    float64   // moving average of the seconds between updates, 0 until the second update
+
+	still     TrackerData // values the freeze detection compares against
+	changedAt time.Time   // when the values last moved by more than freezeEpsilon
+
+	history history // the tracker after each of its last updates, empty unless historySize is set
+}
+
+// rateSmoothing is the weight of a new inter-arrival time in the average
+const rateSmoothing = 0.1
+
+// updateRate folds the time since the previous update into the average
+// interval and returns the resulting rate in Hz, 0 until it is known.
+func (s *trackerState) updateRate(arrival time.Time) float64 {
+	if !s.lastArrival.IsZero() {
+		dt := arrival.Sub(s.lastArrival).Seconds()
+		if s.interval == 0 {
+			s.interval = dt
+		} else {
+			s.interval += rateSmoothing * (dt - s.interval)
+		}
+	}
+	s.lastArrival = arrival
+	if s.interval <= 0 {
+		return 0
+	}
+	return 1 / s.interval
 }
 
 type TrackerManager struct {
-	trackers  map[int]*TrackerData
+	trackers  map[trackerKey]*TrackerData
 	mu        sync.RWMutex
 	updateCh  chan TrackerData
 	forwardCh chan TrackerData
+
+	closeMu sync.RWMutex  // guards closed against sends on updateCh
+	closed  bool          // set once Shutdown has closed updateCh
+	done    chan struct{} // closed when processUpdates has drained updateCh
+	stop    chan struct{} // closed by Shutdown to stop background goroutines
+	abandon chan struct{} // closed when the context given to Shutdown is done
+
+	// set by processUpdates before it returns, see Shutdown
+	releasedOnExit int // held back by the jitter buffer, processed at shutdown
+	droppedOnExit  int // still queued when the Shutdown context was done
+	discardOnExit  int // held back by the jitter buffer when the Shutdown context was done
+
+	state map[trackerKey]*trackerState // guarded by mu, removed together with the tracker
+
+	// removal notices the forwarder has not taken yet because forwardCh was
+	// full, they are retried before the next update goes out. Only used by
+	// processUpdates.
+	removals map[trackerKey]TrackerData
+
+	dropOldest atomic.Bool            // overflow policy, read on the receive path without taking mu
+	input      atomic.Pointer[Config] // schema and input settings for HandleMessage, same reason
+
+	subMu       sync.RWMutex // guards subscribers, held for reading while fanning out
+	subscribers map[chan TrackerData]struct{}
+
+	// tunables, set by Configure and guarded by mu
+	acceptIDs          map[int]bool // when set, updates for other trackers are dropped
+	maxTrackers        int          // 0 is unlimited
+	evictTrackers      bool         // make room for a new tracker beyond maxTrackers instead of rejecting it
+	inversionThreshold float64
+	inversionSamples   int
+	inversionIDs       map[int]bool // when set, only these trackers are corrected
+	inversionSkipIDs   map[int]bool // trackers never corrected
+	smoothingFactor    float64
+	smoothingFactors   map[int]float64 // per tracker ID overrides of smoothingFactor
+	smoothingMode      string          // see Smoothing*, smoothingFactor only applies to SmoothingEMA
+	springHalfLife     float64         // seconds
+	oneEuro            oneEuro
+	positionDeadband   float64
+	rotationDeadband   float64
+	positionAxes       axisMap
+	rotationAxes       axisMap
+	quaternionAxes     axisMap // only remapped by a frame preset
+	mirror             mirror
+	positionMatrix     affine
+	positionScale      scaleOffset
+	positionBounds     bounds
+	dropOutOfBounds    bool // drop updates outside positionBounds instead of clamping them
+	queueHighWater     float64
+	velocity           bool
+	velocityMinDt      time.Duration
+	freezeAfter        time.Duration
+	freezeEpsilon      float64
+	skipInactive       bool
+	jitterWindow       time.Duration
+	historySize        int
+
+	jitter *jitterBuffer // only used by processUpdates
+
+	calibrations     map[trackerKey]Calibration // guarded by mu, kept when the tracker expires
+	calibrationStore fileStore
+
+	disabled      map[trackerKey]bool // guarded by mu, see Disable
+	disabledStore fileStore
 }
 
-func NewTrackerManager() *TrackerManager {
+func NewTrackerManager(bufUpdate, bufForward int) *TrackerManager {
 	tm := &TrackerManager{
-		trackers:  make(map[int]*TrackerData),
-		updateCh:  make(chan TrackerData, 10000), // Buffered channel
-		forwardCh: make(chan TrackerData, 10000), // Buffered channel
+		trackers:    make(map[trackerKey]*TrackerData),
+		state:       make(map[trackerKey]*trackerState),
+		removals:    make(map[trackerKey]TrackerData),
+		subscribers: make(map[chan TrackerData]struct{}),
+		updateCh:    make(chan TrackerData, bufUpdate),  // Buffered channel
+		forwardCh:   make(chan TrackerData, bufForward), // Buffered channel
+		done:        make(chan struct{}),
+		stop:        make(chan struct{}),
+		abandon:     make(chan struct{}),
+		jitter:      newJitterBuffer(),
+
+		calibrations: make(map[trackerKey]Calibration),
+		disabled:     make(map[trackerKey]bool),
+
+		inversionThreshold: DefaultConfig().InversionThreshold,
+		inversionSamples:   DefaultConfig().InversionSamples,
+		positionAxes:       identityAxes,
+		rotationAxes:       identityAxes,
+		quaternionAxes:     identityAxes,
+		mirror:             noMirror,
+		positionMatrix:     identityAffine,
+		positionScale:      identityScaleOffset,
+		positionBounds:     unbounded,
+		queueHighWater:     DefaultConfig().QueueHighWater,
 	}
+	tm.input.Store(DefaultConfig())
 	go tm.processUpdates()
+	go tm.monitorSaturation()
 	return tm
 }
 
+// Configure applies the tunable settings from cfg.
+func (tm *TrackerManager) Configure(cfg *Config) {
+	tm.dropOldest.Store(cfg.OverflowPolicy == OverflowDropOldest)
+	tm.input.Store(cfg)
+
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.acceptIDs = idSet(cfg.TrackerIDs)
+	tm.maxTrackers = cfg.MaxTrackers
+	tm.evictTrackers = cfg.TrackerLimit == LimitEvict
+	tm.inversionThreshold = cfg.InversionThreshold
+	tm.inversionSamples = cfg.InversionSamples
+	tm.inversionIDs = idSet(cfg.InversionIDs)
+	tm.inversionSkipIDs = idSet(cfg.InversionSkipIDs)
+	tm.smoothingFactor = cfg.SmoothingFactor
+	tm.smoothingFactors = cfg.SmoothingFactors
+	for key, state := range tm.state {
+		state.smoothingFactor = tm.smoothingFor(key.id)
+		if cfg.SmoothingMode != tm.smoothingMode {
+			state.smooth = smoothState{} // the other filter starts over
+		}
+	}
+	tm.smoothingMode = cfg.SmoothingMode
+	tm.springHalfLife = cfg.SmoothingHalfLife.Seconds()
+	tm.oneEuro = oneEuro{minCutoff: cfg.SmoothingMinCutoff, beta: cfg.SmoothingBeta, dCutoff: cfg.SmoothingDCutoff}
+	tm.positionDeadband = cfg.PositionDeadband
+	tm.rotationDeadband = cfg.RotationDeadband
+	tm.positionAxes, _ = parseAxisMap(cfg.PositionAxes) // checked by Config.Validate
+	tm.rotationAxes, _ = parseAxisMap(cfg.RotationAxes)
+	tm.quaternionAxes = identityAxes
+	if cfg.FramePreset != "" {
+		tm.positionAxes, tm.rotationAxes, _ = convertFrame(cfg.InputFrame, cfg.FramePreset)
+		tm.quaternionAxes = tm.rotationAxes
+	}
+	tm.mirror, _ = parseMirror(cfg.Mirror)
+	tm.positionMatrix, _ = parseMatrix(cfg.PositionMatrix)
+	tm.positionScale, _ = newScaleOffset(cfg.PositionScale, cfg.PositionOffset)
+	tm.positionBounds, _ = newBounds(cfg.PositionMin, cfg.PositionMax)
+	tm.dropOutOfBounds = cfg.OutOfBounds == OutOfBoundsDrop
+	tm.queueHighWater = cfg.QueueHighWater
+	tm.velocity = cfg.Velocity
+	tm.velocityMinDt = cfg.VelocityMinInterval
+	tm.freezeAfter = cfg.FreezeAfter
+	tm.freezeEpsilon = cfg.FreezeEpsilon
+	tm.skipInactive = cfg.SkipInactive
+	tm.jitterWindow = cfg.JitterWindow
+	if cfg.HistorySize != tm.historySize {
+		for _, state := range tm.state {
+			state.history = history{} // frees the old rings, add sizes new ones
+		}
+	}
+	tm.historySize = cfg.HistorySize
+}
+
 func (tm *TrackerManager) processUpdates() {
-	for data := range tm.updateCh {
-		tm.mu.Lock()
-		if tracker, exists := tm.trackers[data.ID]; exists {
-			if detectOrientationInversion(tracker.Rotation, data.Rotation) {
-				data.Rotation = invertOrientation(data.Rotation)
+	defer close(tm.done)
+
+	// fires when the next update held in the jitter buffer is due
+	timer := time.NewTimer(0)
+	timer.Stop()
+	var release <-chan time.Time
+
+	for {
+		select {
+		case data, ok := <-tm.updateCh:
+			if !ok {
+				held := tm.jitter.release(time.Now(), true)
+				for _, data := range held {
+					tm.process(data)
+				}
+				tm.releasedOnExit = len(held)
+				return
+			}
+			tm.mu.RLock()
+			window := tm.jitterWindow
+			tm.mu.RUnlock()
+			if window <= 0 || data.SourceTime.IsZero() {
+				tm.process(data)
+			} else if !tm.jitter.add(data, time.Now(), window) {
+				metrics.Late.Add(1)
+			}
+		case now := <-release:
+			for _, held := range tm.jitter.release(now, false) {
+				tm.process(held)
 			}
+		case <-tm.abandon:
+			// updateCh is closed by now, so this ends
+			for range tm.updateCh {
+				tm.droppedOnExit++
+			}
+			tm.discardOnExit = len(tm.jitter.release(time.Now(), true))
+			return
+		}
+
+		release = nil
+		if due, ok := tm.jitter.nextDue(); ok {
+			timer.Reset(time.Until(due))
+			release = timer.C
 		}
-		tm.trackers[data.ID] = &data
+	}
+}
+
+// process runs one update through the pipeline and stores and forwards the
+// result.
+func (tm *TrackerManager) process(data TrackerData) {
+	if data.Removed {
+		tm.expire(data)
+		return
+	}
+	now := time.Now()
+	tm.mu.Lock()
+	if tm.acceptIDs != nil && !tm.acceptIDs[data.ID] {
 		tm.mu.Unlock()
+		return
+	}
+	key := data.key()
+	tracker, exists := tm.trackers[key]
+	if !exists && tm.maxTrackers > 0 && len(tm.trackers) >= tm.maxTrackers {
+		if !tm.evictTrackers {
+			tm.mu.Unlock()
+			metrics.TrackerLimit.Inc("rejected")
+			errorLog.Warn("tracker limit", "Tracker limit reached, rejecting new tracker", data.logAttrs()...)
+			return
+		}
+		tm.evictLeastRecent()
+	}
+	if !exists {
+		tracker = &TrackerData{ID: data.ID, Source: data.Source}
+		tm.trackers[key] = tracker
+		tm.state[key] = &trackerState{smoothingFactor: tm.smoothingFor(data.ID)}
+	}
+	state := tm.state[key]
+	tracker.Rate = state.updateRate(now)
+
+	// bring the update into the output coordinate system first, so
+	// everything below and the stored state use the same axes. The
+	// order is remap, matrix, then scale and offset, each working in
+	// the output of the previous step. The mirror comes last and
+	// reflects position, rotation and quaternion together.
+	data.Position = tm.mirror.position(tm.positionScale.apply(tm.positionMatrix.apply(tm.positionAxes.apply(data.Position))))
+	data.Rotation = normalizeAngles(tm.mirror.rotation(tm.rotationAxes.apply(data.Rotation)))
+	data.Quaternion = tm.mirror.quaternion(tm.quaternionAxes.quaternion(data.Quaternion))
+
+	if !finite(data.Position[:]) || !finite(data.Rotation[:]) || !finite(data.Quaternion[:]) {
+		clampNonFinite(&data, tracker)
+		metrics.NonFinite.Inc("clamped")
+	}
+
+	// keep glitched positions out of the stored state as well as the output
+	if data.Fields&FieldPosition != 0 && !tm.positionBounds.contains(data.Position) {
+		if tm.dropOutOfBounds {
+			metrics.OutOfBounds.Inc("dropped")
+			tm.mu.Unlock()
+			return
+		}
+		data.Position = tm.positionBounds.clamp(data.Position)
+		metrics.OutOfBounds.Inc("clamped")
+	}
+
+	// a status message carries no values that could have stopped changing
+	if tm.freezeAfter > 0 && data.Fields != FieldActive {
+		tm.detectFreeze(&data, tracker, state, now)
+	}
+
+	// correct inversions against the stored tracker before smoothing
+	corrects := tm.correctsInversion(data.ID)
+	if corrects && data.Fields&FieldRotation != 0 && tracker.Fields&FieldRotation != 0 {
+		invert, hold := tm.debounceInversion(&state.rotationInversions,
+			detectOrientationInversion(tracker.Rotation, data.Rotation, tm.inversionThreshold))
+		if invert {
+			data.Rotation = invertOrientation(data.Rotation)
+		} else if hold {
+			data.Fields &^= FieldRotation
+		}
+	}
+	if corrects && data.Fields&FieldQuaternion != 0 && tracker.Fields&FieldQuaternion != 0 {
+		invert, hold := tm.debounceInversion(&state.quaternionInversions,
+			detectQuaternionInversion(tracker.Quaternion, data.Quaternion, tm.inversionThreshold))
+		if invert {
+			data.Quaternion = invertQuaternion(data.Quaternion)
+		} else if hold {
+			data.Fields &^= FieldQuaternion
+		}
+	}
+	if tm.smoothingMode == SmoothingSpring || tm.smoothingMode == SmoothingOneEuro || state.smoothingFactor > 0 {
+		tm.smooth(&data, state, data.timestamp(now))
+	}
+	tm.applyDeadband(&data, tracker)
+	if tm.velocity && data.Fields&FieldPosition != 0 {
+		tm.updateVelocity(&data, state, data.timestamp(now))
+	}
+
+	data.LastSeen = now
+	if data.Fields&FieldVelocity != 0 {
+		data.VelocityTime = now
+	}
+	data.Disabled = tm.disabled[key]
+	tracker.merge(data)
+	if tm.historySize > 0 && data.Fields != 0 {
+		state.history.add(*tracker, tm.historySize)
+	}
+	if cal, exists := tm.calibrations[key]; exists {
+		cal.apply(&data) // after merge, the stored tracker stays uncalibrated
+	}
+	// status messages still go through, subscribers see both transitions and
+	// frame mode needs them to leave the tracker out
+	skip := tm.skipInactive && tracker.Inactive && data.Fields != FieldActive
+	tm.mu.Unlock()
+
+	if data.Fields == 0 || skip {
+		return // everything was held back, or the tracker is not tracking
+	}
+
+	tm.sendRemovals()
+	offer(tm.forwardCh, data, tm.dropOldest.Load(), "forward")
+	// the forwarder needs disabled updates to forget what it sent,
+	// subscribers only see what is forwarded
+	if !data.Disabled {
+		tm.publish(data)
+	}
+}
+
+// subscriberBufferSize is the capacity of each Subscribe channel
+const subscriberBufferSize = 256
+
+// Subscribe returns a channel receiving every processed update, the same ones
+// that are forwarded. Updates are dropped for a subscriber that falls behind.
+// The returned func unsubscribes and closes the channel, it is safe to call
+// more than once. The channel is also closed by Shutdown, and is returned
+// already closed once Shutdown has been called.
+func (tm *TrackerManager) Subscribe() (<-chan TrackerData, func()) {
+	ch := make(chan TrackerData, subscriberBufferSize)
+	tm.subMu.Lock()
+	defer tm.subMu.Unlock()
+
+	// once Shutdown has begun nothing is published anymore, and the channel
+	// would never be closed if it were added after Shutdown closed the others
+	tm.closeMu.RLock()
+	closed := tm.closed
+	tm.closeMu.RUnlock()
+	if closed {
+		close(ch)
+		return ch, func() {}
+	}
+	tm.subscribers[ch] = struct{}{}
+
+	return ch, func() {
+		tm.subMu.Lock()
+		defer tm.subMu.Unlock()
+		if _, exists := tm.subscribers[ch]; exists {
+			delete(tm.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// publish hands data to every subscriber without blocking.
+func (tm *TrackerManager) publish(data TrackerData) {
+	tm.subMu.RLock()
+	defer tm.subMu.RUnlock()
+	for ch := range tm.subscribers {
+		select {
+		case ch <- data:
+		default:
+			metrics.Dropped.Inc("subscriber")
+		}
+	}
+}
+
+// clampNonFinite replaces NaN/Inf components of data with the tracker's last
+// good value. Fields without a stored value yet are dropped from the update.
+func clampNonFinite(data *TrackerData, tracker *TrackerData) {
+	clamp := func(field uint8, values, good []float32) {
+		if data.Fields&field == 0 || finite(values) {
+			return
+		}
+		if tracker.Fields&field == 0 {
+			data.Fields &^= field
+			return
+		}
+		for i, v := range values {
+			if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+				values[i] = good[i]
+			}
+		}
+	}
+	clamp(FieldPosition, data.Position[:], tracker.Position[:])
+	clamp(FieldRotation, data.Rotation[:], tracker.Rotation[:])
+	clamp(FieldQuaternion, data.Quaternion[:], tracker.Quaternion[:])
+}
+
+// offer sends data on ch without ever blocking. When ch is full either data
+// itself is dropped, or with dropOldest the oldest queued entry is discarded
+// to make room. An unbuffered ch takes data only while its receiver is
+// waiting, otherwise data is dropped whatever the policy, as nothing is
+// queued that could make room.
+func offer(ch chan TrackerData, data TrackerData, dropOldest bool, queue string) {
+	select {
+	case ch <- data:
+		return
+	default:
+	}
+	metrics.Dropped.Inc(queue)
+	if !dropOldest || cap(ch) == 0 {
+		return
+	}
+
+	select {
+	case <-ch:
+	default:
+	}
+	select {
+	case ch <- data:
+	default:
+		metrics.Dropped.Inc(queue) // lost the slot to a concurrent sender
+	}
+}
+
+// detectFreeze marks the tracker frozen once its values have not moved by
+// more than freezeEpsilon for freezeAfter while updates keep coming in.
+// Must be called with mu held.
+func (tm *TrackerManager) detectFreeze(data *TrackerData, tracker *TrackerData, state *trackerState, now time.Time) {
+	moved := func(field uint8, values, still []float32) bool {
+		if data.Fields&field == 0 {
+			return false
+		}
+		if state.still.Fields&field == 0 {
+			return true
+		}
+		for i := range values {
+			if math.Abs(float64(values[i]-still[i])) > tm.freezeEpsilon {
+				return true
+			}
+		}
+		return false
+	}
+	if moved(FieldPosition, data.Position[:], state.still.Position[:]) ||
+		moved(FieldRotation, data.Rotation[:], state.still.Rotation[:]) ||
+		moved(FieldQuaternion, data.Quaternion[:], state.still.Quaternion[:]) {
+		state.still.merge(*data)
+		state.changedAt = now
+		if tracker.Frozen {
+			tracker.Frozen = false
+			slog.Info("Tracker moving again", tracker.logAttrs()...)
+		}
+		return
+	}
+	if !tracker.Frozen && now.Sub(state.changedAt) >= tm.freezeAfter {
+		tracker.Frozen = true
+		metrics.Freezes.Add(1)
+		slog.Warn("Tracker frozen, still sending but values are not changing",
+			append(tracker.logAttrs(), "for", now.Sub(state.changedAt).Round(time.Millisecond))...)
+	}
+}
+
+// correctsInversion reports whether inversion correction runs for tracker id.
+// Must be called with mu held.
+func (tm *TrackerManager) correctsInversion(id int) bool {
+	if tm.inversionSkipIDs[id] {
+		return false
+	}
+	return len(tm.inversionIDs) == 0 || tm.inversionIDs[id]
+}
+
+// idSet turns a list of tracker IDs into a set, nil for an empty list.
+func idSet(ids []int) map[int]bool {
+	if len(ids) == 0 {
+		return nil
+	}
+	set := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set
+}
+
+// debounceInversion counts consecutive detections and reports whether the
+// sample should be inverted. Until inversionSamples detections in a row have
+// been seen the sample is held back instead, so a one-frame spike is neither
+// corrected nor stored. Must be called with mu held.
+func (tm *TrackerManager) debounceInversion(count *int, detected bool) (invert, hold bool) {
+	if !detected {
+		*count = 0
+		return false, false
+	}
+	*count++
+	if *count >= tm.inversionSamples {
+		return true, false
+	}
+	return false, true
+}
+
+// applyDeadband drops the fields of data that moved less than the deadband in
+// every component since the stored value, which is the last one let through.
+// Must be called with mu held.
+func (tm *TrackerManager) applyDeadband(data *TrackerData, tracker *TrackerData) {
+	within := func(field uint8) bool {
+		return data.Fields&field != 0 && tracker.Fields&field != 0
+	}
+	if tm.positionDeadband > 0 && within(FieldPosition) {
+		still := true
+		for i := 0; i < 3; i++ {
+			still = still && math.Abs(float64(data.Position[i]-tracker.Position[i])) < tm.positionDeadband
+		}
+		if still {
+			data.Fields &^= FieldPosition
+		}
+	}
+	if tm.rotationDeadband > 0 && within(FieldRotation) {
+		still := true
+		for i := 0; i < 3; i++ {
+			still = still && math.Abs(angleDelta(tracker.Rotation[i], data.Rotation[i])) < tm.rotationDeadband
+		}
+		if still {
+			data.Fields &^= FieldRotation
+		}
+	}
+	if tm.rotationDeadband > 0 && within(FieldQuaternion) &&
+		quaternionAngle(tracker.Quaternion, data.Quaternion) < tm.rotationDeadband {
+		data.Fields &^= FieldQuaternion
+	}
+}
+
+// smoothingFor returns the smoothing factor of tracker id, its override or
+// the global one. Must be called with mu held.
+func (tm *TrackerManager) smoothingFor(id int) float64 {
+	if factor, ok := tm.smoothingFactors[id]; ok {
+		return factor
+	}
+	return tm.smoothingFactor
+}
+
+// smooth runs the position and Euler rotation of data through the tracker's
+// filter of smoothingMode. at is when data was sampled. Must be called with mu
+// held.
+func (tm *TrackerManager) smooth(data *TrackerData, ts *trackerState, at time.Time) {
+	state, factor, halfLife := &ts.smooth, ts.smoothingFactor, tm.springHalfLife
+	if data.Fields&FieldPosition != 0 {
+		dt := at.Sub(state.positionTime).Seconds()
+		switch {
+		case !state.hasPosition:
+		case tm.smoothingMode == SmoothingSpring:
+			data.Position = smoothSpring(state.position, data.Position, &state.positionVelocity, halfLife, dt)
+		case tm.smoothingMode == SmoothingOneEuro:
+			data.Position = tm.oneEuro.smooth(state.position, data.Position, &state.positionVelocity, dt)
+		default:
+			data.Position = smoothLinear(state.position, data.Position, factor)
+		}
+		state.position, state.hasPosition, state.positionTime = data.Position, true, at
+	}
+	if data.Fields&FieldRotation != 0 {
+		dt := at.Sub(state.rotationTime).Seconds()
+		switch {
+		case !state.hasRotation:
+		case tm.smoothingMode == SmoothingSpring:
+			data.Rotation = springAngles(state.rotation, data.Rotation, &state.rotationVelocity, halfLife, dt)
+		case tm.smoothingMode == SmoothingOneEuro:
+			data.Rotation = tm.oneEuro.angles(state.rotation, data.Rotation, &state.rotationVelocity, dt)
+		default:
+			data.Rotation = smoothAngles(state.rotation, data.Rotation, factor)
+		}
+		state.rotation, state.hasRotation, state.rotationTime = data.Rotation, true, at
+	}
+}
 
-		tm.forwardCh <- data
+// updateVelocity derives the velocity from the previous position and sets it
+// on data. The first sample only records the position, and samples closer
+// together than velocityMinDt are skipped to avoid spikes from tiny dt. Must
+// be called with mu held.
+func (tm *TrackerManager) updateVelocity(data *TrackerData, state *trackerState, now time.Time) {
+	if state.prevTime.IsZero() {
+		state.prevPosition, state.prevTime = data.Position, now
+		return
 	}
+	dt := now.Sub(state.prevTime)
+	if dt <= 0 || dt < tm.velocityMinDt {
+		return
+	}
+	for i := 0; i < 3; i++ {
+		data.Velocity[i] = float32(float64(data.Position[i]-state.prevPosition[i]) / dt.Seconds())
+	}
+	data.Fields |= FieldVelocity
+	state.prevPosition, state.prevTime = data.Position, now
+}
+
+// HandleMessage parses msg with the configured schema and queues the update.
+// It returns false when msg is not a tracker update, so the caller can hand
+// it on elsewhere.
+func (tm *TrackerManager) HandleMessage(msg *osc.Message, sourceTime time.Time, from net.Addr) bool {
+	cfg := tm.input.Load()
+	if !cfg.Schema.Matches(msg.Address) {
+		return false
+	}
+	data, perr := parseMessage(msg, &cfg.Schema, cfg.NonFinite == NonFiniteClamp)
+	if perr != parseOK {
+		metrics.ParseFailures.Inc(perr.String())
+		// checked first, building the attributes would allocate on every
+		// failing message
+		if slog.Default().Enabled(context.Background(), slog.LevelDebug) {
+			slog.Debug("Dropping tracking message", "addr", msg.Address, "reason", perr.String())
+		}
+		return false
+	}
+	metrics.Parsed.Add(1)
+	data.SourceTime = sourceTime
+	data.Source = cfg.sourceName(from)
+	if from != nil {
+		data.From = from.String()
+	}
+	tm.UpdateTracker(data)
+	return true
 }
 
 func (tm *TrackerManager) UpdateTracker(data TrackerData) {
-	tm.updateCh <- data
+	tm.closeMu.RLock()
+	defer tm.closeMu.RUnlock()
+	if tm.closed {
+		return // late message during shutdown
+	}
+	offer(tm.updateCh, data, tm.dropOldest.Load(), "update")
+}
+
+// Shutdown stops accepting updates, waits for the queued ones to be processed
+// into forwardCh and then closes forwardCh so the forwarder can finish, as well
+// as any subscriber channels. Updates still queued when ctx is done are
+// dropped instead, so a full queue cannot hold up the exit. It returns how
+// many queued updates were processed and how many were dropped.
+//
+// Neither side can block the other on the way out: processUpdates only ever
+// offers to forwardCh, dropping what does not fit, and the forwarder keeps
+// reading until forwardCh is closed, which happens after processUpdates
+// returned. A forwarder stuck in a send only delays main, which stops it with
+// its own context once ctx is done.
+func (tm *TrackerManager) Shutdown(ctx context.Context) (drained, dropped int) {
+	tm.closeMu.Lock()
+	if tm.closed {
+		tm.closeMu.Unlock()
+		return 0, 0
+	}
+	tm.closed = true
+	close(tm.stop)
+	close(tm.updateCh)
+	queued := len(tm.updateCh) // nothing can be added anymore
+	tm.closeMu.Unlock()
+
+	stopAbandon := context.AfterFunc(ctx, func() { close(tm.abandon) })
+	<-tm.done
+	stopAbandon()
+	close(tm.forwardCh)
+
+	tm.subMu.Lock()
+	for ch := range tm.subscribers {
+		delete(tm.subscribers, ch)
+		close(ch)
+	}
+	tm.subMu.Unlock()
+
+	drained = queued - tm.droppedOnExit + tm.releasedOnExit
+	dropped = tm.droppedOnExit + tm.discardOnExit
+	return drained, dropped
 }
 
-func (tm *TrackerManager) GetTrackerData(id int) (TrackerData, bool) {
+// GetTrackerData returns the tracker with id from source, source is "" unless
+// source_namespace is on.
+func (tm *TrackerManager) GetTrackerData(source string, id int) (TrackerData, bool) {
 	tm.mu.RLock()
 	defer tm.mu.RUnlock()
-	if tracker, exists := tm.trackers[id]; exists {
+	if tracker, exists := tm.trackers[trackerKey{source: source, id: id}]; exists {
 		return *tracker, true
 	}
 	return TrackerData{}, false
 }
 
-func detectOrientationInversion(old, new [3]float32) bool {
-	threshold := float32(170.0) // degrees
+// History returns the tracker as it was after each of its last updates,
+// oldest first. ok is false when the tracker is unknown, the slice is empty
+// while history_size is 0.
+func (tm *TrackerManager) History(source string, id int) (samples []TrackerData, ok bool) {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	state, exists := tm.state[trackerKey{source: source, id: id}]
+	if !exists {
+		return nil, false
+	}
+	return state.history.copy(), true
+}
+
+// Snapshot returns a copy of all trackers.
+func (tm *TrackerManager) Snapshot() []TrackerData {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	out := make([]TrackerData, 0, len(tm.trackers))
+	for _, tracker := range tm.trackers {
+		out = append(out, *tracker)
+	}
+	return out
+}
+
+// GetAllTrackers returns a copy of every tracker sorted by source and ID.
+// TrackerData holds no references, so changing the result does not touch the
+// manager.
+func (tm *TrackerManager) GetAllTrackers() []TrackerData {
+	trackers := tm.Snapshot()
+	sort.Slice(trackers, func(i, j int) bool {
+		if trackers[i].Source != trackers[j].Source {
+			return trackers[i].Source < trackers[j].Source
+		}
+		return trackers[i].ID < trackers[j].ID
+	})
+	return trackers
+}
+
+// ActiveCount returns the number of trackers that have not expired.
+func (tm *TrackerManager) ActiveCount() int {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	return len(tm.trackers)
+}
+
+// FrozenCount returns the number of trackers currently detected as frozen.
+func (tm *TrackerManager) FrozenCount() int {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	n := 0
+	for _, tracker := range tm.trackers {
+		if tracker.Frozen {
+			n++
+		}
+	}
+	return n
+}
+
+// StartSweeper removes trackers not seen for longer than ttl, checking every
+// interval. A ttl of 0 keeps trackers forever.
+func (tm *TrackerManager) StartSweeper(ttl, interval time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	go tm.sweepStale(ttl, interval)
+}
+
+// evictLeastRecent removes the tracker seen longest ago to make room for a
+// new one. Must be called with mu held for writing.
+func (tm *TrackerManager) evictLeastRecent() {
+	var oldest *TrackerData
+	var oldestKey trackerKey
+	for key, tracker := range tm.trackers {
+		if oldest == nil || tracker.LastSeen.Before(oldest.LastSeen) {
+			oldest, oldestKey = tracker, key
+		}
+	}
+	if oldest == nil {
+		return
+	}
+	delete(tm.trackers, oldestKey)
+	delete(tm.state, oldestKey)
+	tm.removals[oldestKey] = TrackerData{ID: oldest.ID, Source: oldest.Source, Removed: true}
+	metrics.TrackerLimit.Inc("evicted")
+	errorLog.Warn("tracker limit", "Tracker limit reached, evicting the least recently seen", oldest.logAttrs()...)
+}
+
+// sweepStale queues the removal of every tracker not seen for longer than
+// ttl. processUpdates does the removing, so the forwarder hears of it in
+// order with the updates. A removal lost to a full queue is queued again on
+// the next sweep, the tracker is still stale then.
+func (tm *TrackerManager) sweepStale(ttl, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var stale []TrackerData
+	for {
+		select {
+		case <-tm.stop:
+			return
+		case now := <-ticker.C:
+			stale = stale[:0]
+			tm.mu.RLock()
+			for _, tracker := range tm.trackers {
+				if now.Sub(tracker.LastSeen) > ttl {
+					stale = append(stale, TrackerData{ID: tracker.ID, Source: tracker.Source, LastSeen: tracker.LastSeen, Removed: true})
+				}
+			}
+			tm.mu.RUnlock()
+			for _, notice := range stale {
+				tm.UpdateTracker(notice)
+			}
+		}
+	}
+}
+
+// expire removes the tracker notice names, unless it was updated since the
+// sweep that found it stale, and tells the forwarder.
+func (tm *TrackerManager) expire(notice TrackerData) {
+	key := notice.key()
+	tm.mu.Lock()
+	tracker, exists := tm.trackers[key]
+	if !exists || !tracker.LastSeen.Equal(notice.LastSeen) {
+		tm.mu.Unlock()
+		return
+	}
+	delete(tm.trackers, key)
+	delete(tm.state, key)
+	tm.removals[key] = TrackerData{ID: notice.ID, Source: notice.Source, Removed: true}
+	tm.mu.Unlock()
+
+	slog.Info("Tracker expired", tracker.logAttrs()...)
+	tm.sendRemovals()
+}
+
+// sendRemovals hands the pending removal notices to the forwarder, so it
+// drops what it keeps per tracker. Unlike updates, notices that do not fit
+// are not dropped but kept for the next try, a lost one would leave the
+// forwarder's entries behind for good.
+func (tm *TrackerManager) sendRemovals() {
+	for key, notice := range tm.removals {
+		select {
+		case tm.forwardCh <- notice:
+			delete(tm.removals, key)
+		default:
+			return
+		}
+	}
+}
+
+// how often queue depths are sampled, and how often a full queue is warned about
+const (
+	saturationSampleInterval = 250 * time.Millisecond
+	saturationWarnInterval   = 10 * time.Second
+)
+
+// monitorSaturation samples the queue depths and flags the queues filled
+// beyond queueHighWater, so there is a warning before updates get dropped.
+func (tm *TrackerManager) monitorSaturation() {
+	queues := []struct {
+		name      string
+		ch        chan TrackerData
+		saturated *atomic.Bool
+		lastWarn  time.Time
+	}{
+		{name: "update", ch: tm.updateCh, saturated: &metrics.UpdateSaturated},
+		{name: "forward", ch: tm.forwardCh, saturated: &metrics.ForwardSaturated},
+	}
+
+	ticker := time.NewTicker(saturationSampleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-tm.stop:
+			return
+		case now := <-ticker.C:
+			tm.mu.RLock()
+			highWater := tm.queueHighWater
+			tm.mu.RUnlock()
+			for i := range queues {
+				q := &queues[i]
+				if cap(q.ch) == 0 {
+					continue // unbuffered, never holds anything
+				}
+				depth := len(q.ch)
+				saturated := float64(depth) >= highWater*float64(cap(q.ch))
+				q.saturated.Store(saturated)
+				if saturated && now.Sub(q.lastWarn) >= saturationWarnInterval {
+					slog.Warn("Queue nearly full", "queue", q.name, "depth", depth, "capacity", cap(q.ch))
+					q.lastWarn = now
+				}
+			}
+		}
+	}
+}
+
+// detectOrientationInversion reports whether any axis jumped by more than
+// threshold degrees, measured the short way around so 179 -> -179 is a 2
+// degree move. A threshold <= 0 disables detection.
+func detectOrientationInversion(old, new [3]float32, threshold float64) bool {
+	if threshold <= 0 {
+		return false
+	}
 	for i := 0; i < 3; i++ {
-		if math.Abs(float64(old[i]-new[i])) > float64(threshold) {
+		if math.Abs(angleDelta(old[i], new[i])) > threshold {
 			return true
 		}
 	}
 	return false
 }
 
+// invertOrientation turns every axis by 180 degrees. The result is in
+// (-180,180] like the normalized input, so 0 becomes 180 rather than -180,
+// and inputs outside one turn are wrapped as well.
 func invertOrientation(orientation [3]float32) [3]float32 {
 	inverted := [3]float32{}
 	for i := 0; i < 3; i++ {
-		inverted[i] = orientation[i] + 180
-		if inverted[i] > 180 {
-			inverted[i] -= 360
-		}
+		inverted[i] = float32(wrapAngle(float64(orientation[i]) + 180))
 	}
 	return inverted
 }
 
-func parseMessage(msg *osc.Message) (TrackerData, bool) {
-	parts := strings.Split(msg.Address, "/")
-	if len(parts) < 4 || parts[1] != "tracking" || parts[2] != "trackers" {
-		return TrackerData{}, false
+// detectQuaternionInversion reports whether the angle between the two
+// quaternions, taken as 4-vectors, exceeds threshold degrees. q and -q are the
+// same orientation 180 degrees apart, so with the default threshold this
+// catches sources flipping the quaternion sign between samples. A threshold
+// <= 0 disables detection.
+func detectQuaternionInversion(old, new [4]float32, threshold float64) bool {
+	if threshold <= 0 {
+		return false
+	}
+	var dot, oldLen, newLen float64
+	for i := 0; i < 4; i++ {
+		dot += float64(old[i]) * float64(new[i])
+		oldLen += float64(old[i]) * float64(old[i])
+		newLen += float64(new[i]) * float64(new[i])
+	}
+	if oldLen == 0 || newLen == 0 {
+		return false
 	}
+	cos := math.Max(-1, math.Min(1, dot/math.Sqrt(oldLen*newLen)))
+	return math.Acos(cos)*180/math.Pi > threshold
+}
 
-	id, err := strconv.Atoi(parts[3])
-	if err != nil {
-		return TrackerData{}, false
+// quaternionAngle returns the rotation between two orientations in degrees,
+// q and -q count as the same orientation.
+func quaternionAngle(a, b [4]float32) float64 {
+	var dot, aLen, bLen float64
+	for i := 0; i < 4; i++ {
+		dot += float64(a[i]) * float64(b[i])
+		aLen += float64(a[i]) * float64(a[i])
+		bLen += float64(b[i]) * float64(b[i])
+	}
+	if aLen == 0 || bLen == 0 {
+		return 0
 	}
+	cos := math.Min(1, math.Abs(dot)/math.Sqrt(aLen*bLen))
+	return 2 * math.Acos(cos) * 180 / math.Pi
+}
+
+// invertQuaternion negates q, which describes the same orientation but puts
+// it back in the hemisphere of the previous sample.
+func invertQuaternion(q [4]float32) [4]float32 {
+	return [4]float32{-q[0], -q[1], -q[2], -q[3]}
+}
 
-	if len(msg.Arguments) != 3 {
-		return TrackerData{}, false
+// startListeners listens on every address of addrs, the serve loops report
+// on serverErr. It returns nil after closing what it opened when one fails.
+func startListeners(addrs []string, cfg *Config, d osc.Dispatcher, serverErr chan<- error) []io.Closer {
+	var listeners []io.Closer
+	for _, addr := range addrs {
+		ln, err := startListener(addr, cfg.ListenTransport, cfg.ListenFamily, d, serverErr)
+		if err != nil {
+			slog.Error("Starting listener failed", "addr", addr, "err", err)
+			for _, ln := range listeners {
+				ln.Close()
+			}
+			return nil
+		}
+		listeners = append(listeners, ln)
 	}
+	return listeners
+}
 
-	values := [3]float32{}
-	for i := 0; i < 3; i++ {
-		if v, ok := msg.Arguments[i].(float32); ok {
-			values[i] = v
-		} else {
-			return TrackerData{}, false
+func main() {
+	configPath := flag.String("config", "./oscwrench.yaml", "path to the YAML or JSON config file")
+	debugAddr := flag.String("debug-addr", "", "address for the debug HTTP server, disabled when empty")
+	recordPath := flag.String("record", "", "write every received OSC message to this file")
+	replayPath := flag.String("replay", "", "feed a file written by --record through the pipeline instead of listening")
+	replaySpeed := flag.Float64("replay-speed", 1, "replay speed multiplier, 0 replays as fast as possible")
+	replayLoop := flag.Bool("replay-loop", false, "start the replay over when the file ends")
+	simulateCount := flag.Int("simulate", 0, "generate this many synthetic trackers instead of listening")
+	simulateRate := flag.Float64("simulate-rate", 60, "updates per second of each synthetic tracker")
+	simulateListen := flag.Bool("simulate-listen", false, "keep the OSC listener running alongside --simulate")
+	passthrough := flag.Bool("passthrough", false, "forward messages that are not tracker updates to the destinations unchanged")
+	dryRun := flag.Bool("dry-run", false, "run the pipeline but only log what would be forwarded (at debug level)")
+	pprofFlag := flag.Bool("pprof", false, "serve pprof profiles under /debug/pprof/ on the debug server")
+	logLevelFlag := flag.String("log-level", "", "log level (debug, info, warn, error), overrides the config")
+	mirrorFlag := flag.String("mirror", "", "mirror the tracking space along this axis (x, y or z), overrides the config")
+	checkConfigFlag := flag.Bool("check-config", false, "validate the config, print the result and exit (1 on errors)")
+	printConfigFlag := flag.Bool("print-config", false, "print the effective config as YAML, with where each setting comes from, and exit")
+	flag.Parse()
+
+	// loadConfig reads the config file and applies the flag overrides
+	loadConfig := func() (*Config, error) {
+		cfg, err := LoadConfig(*configPath)
+		if err != nil {
+			return nil, err
+		}
+		if *debugAddr != "" {
+			cfg.DebugAddr = *debugAddr
+		}
+		if *logLevelFlag != "" {
+			cfg.LogLevel = *logLevelFlag
+			if _, err := parseLogLevel(cfg.LogLevel); err != nil {
+				return nil, err
+			}
+		}
+		if *mirrorFlag != "" {
+			cfg.Mirror = *mirrorFlag
+			if _, err := parseMirror(cfg.Mirror); err != nil {
+				return nil, errors.New("--mirror: " + err.Error())
+			}
 		}
+		return cfg, nil
 	}
 
-	data := TrackerData{ID: id}
-	if strings.Contains(msg.Address, "position") {
-		data.Position = values
-	} else if strings.Contains(msg.Address, "rotation") {
-		data.Rotation = values
-	} else {
-		return TrackerData{}, false
+	if *printConfigFlag {
+		cfg, err := loadConfig()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		overrides := make(map[string]string)
+		for key, value := range map[string]string{"debug_addr": *debugAddr, "log_level": *logLevelFlag, "mirror": *mirrorFlag} {
+			if value != "" {
+				overrides[key] = "--" + strings.ReplaceAll(key, "_", "-")
+			}
+		}
+		if err := printConfig(os.Stdout, *configPath, cfg, overrides); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
 	}
 
-	return data, true
-}
+	if *checkConfigFlag {
+		if !checkConfig(os.Stdout, *configPath, loadConfig) {
+			os.Exit(1)
+		}
+		return
+	}
 
-func main() {
-	// todo: change these to config file
-	addr := "127.0.0.1:9009" // this applications OSC listener
-	destAddr := "127.0.0.1"  // destination OSC server address
-	trackerManager := NewTrackerManager()
+	cfg, err := loadConfig()
+	if err != nil {
+		slog.Error("Loading config failed", "err", err)
+		return
+	}
+	level, _ := parseLogLevel(cfg.LogLevel) // checked by loadConfig
+	logLevel.Set(level)
+	setupLogging(cfg.LogFormat)
+	errorLog.SetWindow(cfg.LogThrottle)
+
+	// the settings used by the handler, swapped on reload
+	var liveCfg atomic.Pointer[Config]
+	liveCfg.Store(cfg)
+
+	if *dryRun {
+		slog.Info("Dry run, nothing will be forwarded")
+	}
+	if *simulateCount > 0 && *simulateRate <= 0 {
+		slog.Error("--simulate-rate must be positive")
+		return
+	}
+
+	trackerManager := NewTrackerManager(cfg.UpdateBufferSize, cfg.ForwardBufferSize)
+	trackerManager.Configure(cfg)
+	trackerManager.StartSweeper(cfg.TrackerTTL, cfg.SweepInterval)
+	if cfg.CalibrationFile != "" {
+		if err := trackerManager.LoadCalibrations(cfg.CalibrationFile); err != nil {
+			slog.Error("Loading calibrations failed", "err", err)
+			return
+		}
+	}
+	if cfg.DisabledFile != "" {
+		if err := trackerManager.LoadDisabled(cfg.DisabledFile); err != nil {
+			slog.Error("Loading disabled trackers failed", "err", err)
+			return
+		}
+	}
 
 	// Start the forwarder
-	go forwardUpdatedData(destAddr, trackerManager.forwardCh)
+	forwardDone := make(chan struct{})
+	dests := newDestinations(cfg.AllDestinations(), *dryRun, newRetryPolicy(cfg), newBreaker(cfg))
+	if len(dests) == 0 {
+		slog.Warn("No destinations configured, updates are tracked but not forwarded")
+	}
+	fwd := newForwarder(dests, cfg)
+	forwardCtx, stopForwarding := context.WithCancel(context.Background())
+	defer stopForwarding()
+	go func() {
+		fwd.forwardUpdatedData(forwardCtx, trackerManager.forwardCh)
+		close(forwardDone)
+	}()
 
-	d := osc.NewStandardDispatcher()
-	err := d.AddMsgHandler("*", func(msg *osc.Message) {
-		if strings.Contains(msg.Address, "tracking") {
-			data, ok := parseMessage(msg)
-			if !ok {
-				return
-			}
-			trackerManager.UpdateTracker(data)
+	var recorder *Recorder
+	if *recordPath != "" {
+		recorder, err = NewRecorder(*recordPath)
+		if err != nil {
+			slog.Error("Opening record file failed", "err", err)
+			return
+		}
+		slog.Info("Recording received messages", "path", *recordPath)
+	}
+
+	handle := func(msg *osc.Message, sourceTime time.Time, from net.Addr) {
+		cfg := liveCfg.Load()
+		metrics.Received.Add(1)
+		if recorder != nil {
+			recorder.Record(msg, time.Now())
+		}
+		if cfg.QueryAddress != "" && msg.Address == cfg.QueryAddress {
+			answerQuery(trackerManager, cfg.QueryAddress, from)
+			return
+		}
+		if trackerManager.HandleMessage(msg, sourceTime, from) {
+			return
 		}
 
 		// todo: additional handlers here
-	})
 
-	if err != nil {
-		log.Println(err)
-		return
+		if *passthrough {
+			fwd.Passthrough(msg)
+		}
 	}
 
-	server := &osc.Server{
-		Addr:       addr,
-		Dispatcher: d,
+	d := &dispatcher{handle: handle}
+
+	if *pprofFlag && cfg.DebugAddr == "" {
+		slog.Warn("--pprof has no effect without a debug address")
+	}
+	var debugServer *http.Server
+	if cfg.DebugAddr != "" {
+		debugServer = startDebugServer(cfg.DebugAddr, trackerManager, *pprofFlag, cfg.DebugWebSocket)
+	}
+	var stream net.Listener
+	if cfg.StreamAddr != "" {
+		if stream, err = startStream(cfg.StreamAddr, trackerManager); err != nil {
+			slog.Error("Starting update stream failed", "addr", cfg.StreamAddr, "err", err)
+			return
+		}
 	}
 
-	// todo: informative senders here
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
 
-	log.Println("Starting listener on", addr)
-	if err := server.ListenAndServe(); err != nil {
-		log.Println(err)
+	// reload re-reads the config and applies whatever can change while
+	// running, a config that fails to load or validate keeps the old one
+	reload := func() {
+		next, err := loadConfig()
+		if err != nil {
+			slog.Error("Reloading config failed, keeping the old one", "err", err)
+			return
+		}
+		current := liveCfg.Load()
+		if changed := current.restartRequired(next); len(changed) > 0 {
+			slog.Warn("Some settings only change on restart", "settings", changed)
+		}
+		level, _ := parseLogLevel(next.LogLevel)
+		logLevel.Set(level)
+		setupLogging(next.LogFormat)
+		errorLog.SetWindow(next.LogThrottle)
+		trackerManager.Configure(next)
+		dests := newDestinations(next.AllDestinations(), *dryRun, newRetryPolicy(next), newBreaker(next))
+		if len(dests) == 0 {
+			slog.Warn("No destinations configured, updates are tracked but not forwarded")
+		}
+		fwd.Reload(next, dests)
+		liveCfg.Store(next)
+		slog.Info("Config reloaded", "path", *configPath)
+	}
+
+	stop := make(chan struct{})
+	listenAddrs := cfg.AllListenAddrs()
+	serverErr := make(chan error, 2*len(listenAddrs)+1) // a dual-stack fallback serves two sockets
+	var listeners []io.Closer
+	if *simulateCount > 0 {
+		go func() {
+			slog.Info("Simulating trackers", "count", *simulateCount, "rate", *simulateRate)
+			simulate(trackerManager, *simulateCount, *simulateRate, stop)
+		}()
+	}
+	if *replayPath != "" {
+		go func() {
+			slog.Info("Replaying", "path", *replayPath, "speed", *replaySpeed, "loop", *replayLoop)
+			health.Listening.Store(true)
+			serverErr <- replayFile(*replayPath, *replaySpeed, *replayLoop, handle, stop)
+		}()
+	} else if *simulateCount > 0 && !*simulateListen {
+		health.Listening.Store(true)
+	} else if listeners = startListeners(listenAddrs, cfg, d, serverErr); listeners == nil {
 		return
 	}
-}
 
-func forwardUpdatedData(destAddr string, forwardCh <-chan TrackerData) {
-	client := osc.NewClient(destAddr, 9010) // todo: change these to config file
-	for data := range forwardCh {
-		// Send position
-		if data.Position != [3]float32{} {
-			posMsg := osc.NewMessage(fmt.Sprintf("/tracking/trackers/%d/position", data.ID))
-			for _, v := range data.Position {
-				posMsg.Append(v)
+	// the watchdog only arms once traffic was seen, so a setup that is
+	// merely quiet is not taken for a dead socket
+	var watchdogTick <-chan time.Time
+	if len(listeners) > 0 {
+		var watchdog *time.Ticker
+		watchdog, watchdogTick = newTicker(cfg.ListenerWatchdog / 4)
+		defer stopTicker(watchdog)
+	}
+	var lastReceived uint64
+	var lastTraffic time.Time // zero while disarmed
+	var staleErr chan error   // serve loops of listeners closed by a restart
+
+wait:
+	for {
+		select {
+		case <-hupCh:
+			reload()
+		case now := <-watchdogTick:
+			if received := metrics.Received.Load(); received != lastReceived {
+				lastReceived, lastTraffic = received, now
+				continue
 			}
-			err := client.Send(posMsg)
-			if err != nil {
-				log.Printf("Error sending position: %v\n", err)
+			if lastTraffic.IsZero() || now.Sub(lastTraffic) < cfg.ListenerWatchdog {
+				continue
 			}
-		}
-
-		// Send rotation
-		if data.Rotation != [3]float32{} {
-			rotMsg := osc.NewMessage(fmt.Sprintf("/tracking/trackers/%d/rotation", data.ID))
-			for _, v := range data.Rotation {
-				rotMsg.Append(v)
+			lastTraffic = time.Time{}
+			if !liveCfg.Load().ListenerRestart {
+				slog.Warn("No messages received, the listener may have stopped", "silence", cfg.ListenerWatchdog.String())
+				continue
+			}
+			slog.Warn("No messages received, restarting the listener", "silence", cfg.ListenerWatchdog.String())
+			for _, ln := range listeners {
+				ln.Close()
 			}
-			err := client.Send(rotMsg)
-			if err != nil {
-				log.Printf("Error sending rotation: %v\n", err)
+			// the closed serve loops report on the old channel, which
+			// must not end the program
+			staleErr, serverErr = serverErr, make(chan error, cap(serverErr))
+			if listeners = startListeners(listenAddrs, cfg, d, serverErr); listeners == nil {
+				break wait
 			}
+			metrics.ListenerRestarts.Add(1)
+		case <-staleErr:
+			// a closed serve loop marks the listener down on its way
+			// out, after the new one already marked it up
+			health.Listening.Store(true)
+		case sig := <-sigCh:
+			slog.Info("Shutting down", "signal", sig.String())
+			break wait
+		case err := <-serverErr:
+			if err != nil && !errors.Is(err, net.ErrClosed) {
+				slog.Error("Listener failed", "err", err)
+			}
+			break wait
+		}
+	}
+
+	close(stop)
+	for _, ln := range listeners {
+		ln.Close()
+	}
+	if recorder != nil {
+		if err := recorder.Close(); err != nil {
+			slog.Error("Closing record file failed", "err", err)
 		}
 	}
+	if debugServer != nil {
+		debugServer.Close()
+	}
+	if stream != nil {
+		stream.Close()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), liveCfg.Load().DrainTimeout)
+	defer cancel()
+	drained, dropped := trackerManager.Shutdown(ctx)
+
+	select {
+	case <-forwardDone:
+	case <-ctx.Done():
+		// whatever the forwarder has not taken yet is lost on exit
+		stopForwarding()
+		dropped += len(trackerManager.forwardCh)
+		slog.Warn("Timed out waiting for forwarder to finish")
+	}
+	if dropped > 0 {
+		slog.Warn("Dropped queued updates on shutdown", "drained", drained, "dropped", dropped)
+	} else if drained > 0 {
+		slog.Info("Drained queued updates", "drained", drained)
+	}
 }
@@ -0,0 +1,758 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/crgimenes/go-osc"
+	"math"
+	"net"
+	"runtime"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestManager returns a manager configured with cfg that is shut down
+// when the test ends. Tests call process directly, so updates go through the
+// pipeline in order and their results are on forwardCh right after.
+func newTestManager(t *testing.T, cfg *Config) *TrackerManager {
+	t.Helper()
+	tm := NewTrackerManager(cfg.UpdateBufferSize, cfg.ForwardBufferSize)
+	tm.Configure(cfg)
+	t.Cleanup(func() { tm.Shutdown(context.Background()) })
+	return tm
+}
+
+// forwarded takes everything queued on forwardCh so far.
+func forwarded(tm *TrackerManager) []TrackerData {
+	var out []TrackerData
+	for {
+		select {
+		case data := <-tm.forwardCh:
+			out = append(out, data)
+		default:
+			return out
+		}
+	}
+}
+
+func rotation(id int, pitch, yaw, roll float32) TrackerData {
+	return TrackerData{ID: id, Rotation: [3]float32{pitch, yaw, roll}, Fields: FieldRotation}
+}
+
+func TestInversionThreshold(t *testing.T) {
+	for _, c := range []struct {
+		threshold float64
+		yaw       float32
+		want      [3]float32
+	}{
+		{90, 89, [3]float32{0, 89, 0}},
+		{90, 90, [3]float32{0, 90, 0}},
+		{90, 91, [3]float32{180, -89, 180}},
+		{0, 179, [3]float32{0, 179, 0}},
+		{-1, 179, [3]float32{0, 179, 0}},
+	} {
+		cfg := DefaultConfig()
+		cfg.InversionThreshold = c.threshold
+		tm := newTestManager(t, cfg)
+		tm.process(rotation(1, 0, 0, 0))
+		// only one axis crosses
+		tm.process(rotation(1, 0, c.yaw, 0))
+		if tracker, _ := tm.GetTrackerData("", 1); tracker.Rotation != c.want {
+			t.Errorf("threshold %v: yaw 0 -> %v stored as %v, want %v", c.threshold, c.yaw, tracker.Rotation, c.want)
+		}
+	}
+}
+
+func TestNonFiniteAxis(t *testing.T) {
+	nan := float32(math.NaN())
+	msg := osc.NewMessage("/tracking/trackers/1/position", float32(5), nan, float32(7))
+	schema := DefaultConfig().Schema
+
+	if _, perr := parseMessage(msg, &schema, false); perr != parseNonFinite {
+		t.Errorf("non_finite: reject parsed NaN with %v, want %v", perr, parseNonFinite)
+	}
+
+	cfg := DefaultConfig()
+	cfg.NonFinite = NonFiniteClamp
+	tm := newTestManager(t, cfg)
+	tm.process(position(1, 1, 2, 3))
+	data, perr := parseMessage(msg, &schema, true)
+	if perr != parseOK {
+		t.Fatalf("non_finite: clamp parsed NaN with %v", perr)
+	}
+	tm.process(data)
+	if tracker, _ := tm.GetTrackerData("", 1); tracker.Position != [3]float32{5, 2, 7} {
+		t.Errorf("clamped to %v, want [5 2 7] keeping the last good Y", tracker.Position)
+	}
+}
+
+func TestGetAllTrackers(t *testing.T) {
+	tm := newTestManager(t, DefaultConfig())
+	for _, id := range []int{3, 10, 2, 1} {
+		tm.process(TrackerData{ID: id, Fields: FieldPosition})
+	}
+
+	trackers := tm.GetAllTrackers()
+	var got []int
+	for _, tracker := range trackers {
+		got = append(got, tracker.ID)
+	}
+	if want := []int{1, 2, 3, 10}; !slices.Equal(got, want) {
+		t.Errorf("GetAllTrackers() in order %v, want %v", got, want)
+	}
+
+	trackers[0].Position = [3]float32{9, 9, 9}
+	trackers[0].ID = 99
+	if tracker, _ := tm.GetTrackerData("", 1); tracker.Position != [3]float32{} {
+		t.Errorf("changing the result moved the stored tracker to %v", tracker.Position)
+	}
+	if _, exists := tm.GetTrackerData("", 99); exists {
+		t.Error("changing the result renamed the stored tracker")
+	}
+}
+
+func TestInversionDebounce(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.InversionSamples = 3
+	tm := newTestManager(t, cfg)
+	tm.process(rotation(1, 0, 0, 0))
+	forwarded(tm)
+
+	// a one-frame spike is dropped, not corrected
+	tm.process(rotation(1, 0, 175, 0))
+	tm.process(rotation(1, 0, 1, 0))
+	out := forwarded(tm)
+	if len(out) != 1 || out[0].Rotation != [3]float32{0, 1, 0} {
+		t.Errorf("spike forwarded %+v, want only the following [0 1 0]", out)
+	}
+
+	// a sustained flip is corrected from the third sample on
+	for i := 0; i < 4; i++ {
+		tm.process(rotation(1, 0, -179, 0))
+	}
+	out = forwarded(tm)
+	if len(out) != 2 {
+		t.Fatalf("sustained flip forwarded %d updates, want 2: %+v", len(out), out)
+	}
+	for _, data := range out {
+		if data.Rotation != [3]float32{180, 1, 180} {
+			t.Errorf("flip forwarded as %v, want corrected to [180 1 180]", data.Rotation)
+		}
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	tm := newTestManager(t, DefaultConfig())
+	updates, unsubscribe := tm.Subscribe()
+
+	tm.process(rotation(1, 0, 10, 0))
+	if got := <-updates; got.ID != 1 || got.Rotation != [3]float32{0, 10, 0} {
+		t.Errorf("subscriber got %+v, want the forwarded update", got)
+	}
+
+	unsubscribe()
+	unsubscribe()
+	if _, ok := <-updates; ok {
+		t.Error("channel still open after unsubscribe")
+	}
+}
+
+func TestSubscribeAfterShutdown(t *testing.T) {
+	tm := newTestManager(t, DefaultConfig())
+	before, _ := tm.Subscribe()
+	tm.Shutdown(context.Background())
+
+	if _, ok := <-before; ok {
+		t.Error("subscriber channel still open after Shutdown")
+	}
+	after, unsubscribe := tm.Subscribe()
+	if _, ok := <-after; ok {
+		t.Error("Subscribe after Shutdown returned an open channel")
+	}
+	unsubscribe()
+}
+
+func TestDeadbandHoldsStillTracker(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.PositionDeadband = 0.001
+	cfg.RotationDeadband = 0.5
+	tm := newTestManager(t, cfg)
+
+	still := position(1, 1, 2, 3)
+	still.Rotation, still.Fields = [3]float32{10, 20, 30}, still.Fields|FieldRotation
+	tm.process(still)
+	if out := forwarded(tm); len(out) != 1 {
+		t.Fatalf("first sample forwarded %d times, want once", len(out))
+	}
+	for i := 0; i < 10; i++ {
+		jitter := still
+		jitter.Position[i%3] += 0.0005
+		jitter.Rotation[i%3] -= 0.25
+		tm.process(still)
+		tm.process(jitter)
+	}
+	if out := forwarded(tm); len(out) != 0 {
+		t.Errorf("still tracker forwarded %d more updates, want none: %+v", len(out), out)
+	}
+
+	moved := still
+	moved.Position[0] += 0.01
+	tm.process(moved)
+	if out := forwarded(tm); len(out) != 1 || out[0].Fields != FieldPosition {
+		t.Errorf("moving past the deadband forwarded %+v, want only the position", out)
+	}
+}
+
+func TestTrackerWhitelist(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TrackerIDs = []int{1, 3}
+	tm := newTestManager(t, cfg)
+	for id := 1; id <= 4; id++ {
+		tm.process(position(id, 1, 2, 3))
+	}
+
+	var ids []int
+	for _, data := range forwarded(tm) {
+		ids = append(ids, data.ID)
+	}
+	if !slices.Equal(ids, []int{1, 3}) {
+		t.Errorf("forwarded trackers %v, want only [1 3]", ids)
+	}
+	if _, exists := tm.GetTrackerData("", 2); exists {
+		t.Error("tracker 2 is tracked although it is not listed")
+	}
+}
+
+func TestUpdateRate(t *testing.T) {
+	var state trackerState
+	start := time.Now()
+	if rate := state.updateRate(start); rate != 0 {
+		t.Errorf("rate %v after the first update, want 0 until it is known", rate)
+	}
+	var rate float64
+	for i := 1; i < 200; i++ {
+		// 60 Hz with every other update 2ms late
+		at := start.Add(time.Duration(i) * time.Second / 60)
+		if i%2 == 1 {
+			at = at.Add(2 * time.Millisecond)
+		}
+		rate = state.updateRate(at)
+	}
+	if math.Abs(rate-60) > 60*0.05 {
+		t.Errorf("rate %.2f Hz, want 60 within 5%%", rate)
+	}
+
+	tm := newTestManager(t, DefaultConfig())
+	tm.process(position(1, 1, 2, 3))
+	tm.process(position(1, 1, 2, 3))
+	tracker, _ := tm.GetTrackerData("", 1)
+	tm.process(TrackerData{ID: 1, LastSeen: tracker.LastSeen, Removed: true})
+	tm.process(position(1, 1, 2, 3))
+	if tracker, _ := tm.GetTrackerData("", 1); tracker.Rate != 0 {
+		t.Errorf("rate %v after the tracker expired, want it to start over at 0", tracker.Rate)
+	}
+}
+
+func TestDetectOrientationInversion(t *testing.T) {
+	for _, c := range []struct {
+		old, new  [3]float32
+		threshold float64
+		want      bool
+	}{
+		{[3]float32{0, 0, 0}, [3]float32{169.9, 0, 0}, 170, false},
+		{[3]float32{0, 0, 0}, [3]float32{170, 0, 0}, 170, false},
+		{[3]float32{0, 0, 0}, [3]float32{170.1, 0, 0}, 170, true},
+		{[3]float32{0, 0, 0}, [3]float32{0, -170.1, 0}, 170, true},
+		{[3]float32{0, 0, 0}, [3]float32{0, 0, 171}, 170, true},
+		{[3]float32{0, 0, 0}, [3]float32{169, -169, 169}, 170, false},
+		{[3]float32{10, 20, 30}, [3]float32{-170, 20, -150}, 170, true},
+		// the short way around
+		{[3]float32{179, 0, 0}, [3]float32{-179, 0, 0}, 170, false},
+		{[3]float32{-175, 0, 0}, [3]float32{175, 0, 0}, 170, false},
+		{[3]float32{5, 0, 0}, [3]float32{-170, 0, 0}, 170, true},
+		{[3]float32{-90, 0, 0}, [3]float32{90, 0, 0}, 170, true},
+		{[3]float32{0, 0, 0}, [3]float32{180, 0, 0}, 0, false},
+		{[3]float32{0, 0, 0}, [3]float32{180, 0, 0}, -1, false},
+	} {
+		if got := detectOrientationInversion(c.old, c.new, c.threshold); got != c.want {
+			t.Errorf("detectOrientationInversion(%v, %v, %v) = %v, want %v", c.old, c.new, c.threshold, got, c.want)
+		}
+	}
+}
+
+func TestInvertOrientation(t *testing.T) {
+	for _, c := range []struct{ in, want [3]float32 }{
+		{[3]float32{0, 180, -180}, [3]float32{180, 0, 0}},
+		{[3]float32{90, -90, 10}, [3]float32{-90, 90, -170}},
+		{[3]float32{-10, 179, -179}, [3]float32{170, -1, 1}},
+		{[3]float32{370, -370, 540}, [3]float32{-170, 170, 0}},
+	} {
+		got := invertOrientation(c.in)
+		if got != c.want {
+			t.Errorf("invertOrientation(%v) = %v, want %v", c.in, got, c.want)
+		}
+		for _, a := range got {
+			if a <= -180 || a > 180 {
+				t.Errorf("invertOrientation(%v) = %v, out of (-180,180]", c.in, got)
+			}
+		}
+	}
+}
+
+func TestInversionSamplesHoldBack(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.InversionSamples = 3
+	tm := newTestManager(t, cfg)
+
+	tm.process(rotation(1, 0, 0, 0))
+	forwarded(tm)
+	for i := 1; i < 3; i++ {
+		tm.process(rotation(1, 0, 175, 0))
+		if out := forwarded(tm); len(out) != 0 {
+			t.Fatalf("inverted sample %d forwarded as %+v, want it held back", i, out)
+		}
+		if tracker, _ := tm.GetTrackerData("", 1); tracker.Rotation != [3]float32{} {
+			t.Fatalf("held back sample %d stored as %v", i, tracker.Rotation)
+		}
+	}
+	tm.process(rotation(1, 0, 175, 0))
+	out := forwarded(tm)
+	if len(out) != 1 || out[0].Rotation != [3]float32{180, -5, 180} {
+		t.Errorf("third inverted sample forwarded as %+v, want it corrected to [180 -5 180]", out)
+	}
+}
+
+func TestVelocityTime(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Velocity = true
+	cfg.VelocityMinInterval = 0
+	tm := newTestManager(t, cfg)
+
+	tm.process(position(1, 0, 0, 0))
+	time.Sleep(time.Millisecond)
+	tm.process(position(1, 1, 0, 0))
+	time.Sleep(time.Millisecond)
+	tm.process(rotation(1, 0, 10, 0))
+
+	out := forwarded(tm)
+	if len(out) != 3 {
+		t.Fatalf("forwarded %d updates, want 3", len(out))
+	}
+	if out[1].Fields&FieldVelocity == 0 || !out[1].VelocityTime.Equal(out[1].LastSeen) {
+		t.Errorf("update with a velocity stamped %v, want its arrival %v", out[1].VelocityTime, out[1].LastSeen)
+	}
+	if !out[2].VelocityTime.IsZero() {
+		t.Errorf("rotation-only update stamped a velocity time %v", out[2].VelocityTime)
+	}
+	if tracker, _ := tm.GetTrackerData("", 1); !tracker.VelocityTime.Equal(out[1].LastSeen) {
+		t.Errorf("stored velocity time %v, want the update that carried it at %v", tracker.VelocityTime, out[1].LastSeen)
+	}
+}
+
+// waitForTracker polls until the tracker is stored, HandleMessage only
+// queues the update.
+func waitForTracker(t *testing.T, tm *TrackerManager, source string, id int) TrackerData {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if tracker, exists := tm.GetTrackerData(source, id); exists {
+			return tracker
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("tracker %d from %q never appeared", id, source)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHandleMessage(t *testing.T) {
+	tm := newTestManager(t, DefaultConfig())
+	from := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 7), Port: 4000}
+
+	if tm.HandleMessage(osc.NewMessage("/other/thing", float32(1)), time.Time{}, from) {
+		t.Error("a message outside the schema was taken")
+	}
+	if tm.HandleMessage(osc.NewMessage("/tracking/trackers/4/position", "x"), time.Time{}, from) {
+		t.Error("a malformed tracker message was taken")
+	}
+	if !tm.HandleMessage(osc.NewMessage("/tracking/trackers/4/position", float32(1), float32(2), float32(3)), time.Time{}, from) {
+		t.Fatal("a tracker message was not taken")
+	}
+
+	tracker := waitForTracker(t, tm, "", 4)
+	if tracker.Position != [3]float32{1, 2, 3} || tracker.From != from.String() {
+		t.Errorf("stored %+v, want position [1 2 3] from %s", tracker, from)
+	}
+}
+
+func TestPositionBounds(t *testing.T) {
+	for _, c := range []struct {
+		policy string
+		in     TrackerData
+		want   [3]float32 // of the forwarded update
+		drop   bool
+	}{
+		{OutOfBoundsClamp, position(1, 0.5, 1, -0.5), [3]float32{0.5, 1, -0.5}, false},
+		{OutOfBoundsClamp, position(1, 5, -1, 0), [3]float32{2, 0, 0}, false},
+		{OutOfBoundsClamp, position(1, -5, 9, -9), [3]float32{-2, 3, -1}, false},
+		{OutOfBoundsDrop, position(1, 0.5, 1, -0.5), [3]float32{0.5, 1, -0.5}, false},
+		{OutOfBoundsDrop, position(1, 2.1, 1, 0), [3]float32{}, true},
+	} {
+		cfg := DefaultConfig()
+		cfg.PositionMin, cfg.PositionMax = []float64{-2, 0, -1}, []float64{2, 3, 1}
+		cfg.OutOfBounds = c.policy
+		tm := newTestManager(t, cfg)
+		tm.process(c.in)
+		out := forwarded(tm)
+		if c.drop {
+			if len(out) != 0 {
+				t.Errorf("%s: %v forwarded as %+v, want it dropped", c.policy, c.in.Position, out)
+			}
+			if tracker, _ := tm.GetTrackerData("", 1); tracker.Fields&FieldPosition != 0 {
+				t.Errorf("%s: dropped %v was stored", c.policy, c.in.Position)
+			}
+			continue
+		}
+		if len(out) != 1 || out[0].Position != c.want {
+			t.Errorf("%s: %v forwarded as %+v, want %v", c.policy, c.in.Position, out, c.want)
+		}
+	}
+}
+
+// status returns a status update saying whether tracker id is active.
+func status(id int, active bool) TrackerData {
+	return TrackerData{ID: id, Inactive: !active, Fields: FieldActive}
+}
+
+func TestSkipInactive(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.SkipInactive = true
+	tm := newTestManager(t, cfg)
+	for _, data := range []TrackerData{
+		position(1, 1, 0, 0),
+		status(1, false),
+		position(1, 2, 0, 0), // held back while inactive
+		status(1, true),
+		position(1, 3, 0, 0),
+	} {
+		tm.process(data)
+	}
+
+	var got []string
+	for _, data := range forwarded(tm) {
+		if data.Fields == FieldActive {
+			got = append(got, fmt.Sprintf("active=%v", !data.Inactive))
+		} else {
+			got = append(got, fmt.Sprintf("x=%v", data.Position[0]))
+		}
+	}
+	if want := []string{"x=1", "active=false", "active=true", "x=3"}; !slices.Equal(got, want) {
+		t.Errorf("forwarded %v, want %v", got, want)
+	}
+	if tracker, _ := tm.GetTrackerData("", 1); tracker.Position[0] != 3 || tracker.Inactive {
+		t.Errorf("stored x=%v inactive=%v, want x=3 and active", tracker.Position[0], tracker.Inactive)
+	}
+}
+
+// checkGoroutines fails t unless the goroutine count drops back to before
+// within a few seconds.
+func checkGoroutines(t *testing.T, before int) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<20)
+			t.Fatalf("%d goroutines left, %d before:\n%s", runtime.NumGoroutine(), before, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// load calls UpdateTracker from several goroutines, as the listeners do,
+// until the returned func is called.
+func load(tm *TrackerManager) (stop func()) {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			for x := float32(0); ; x++ {
+				select {
+				case <-done:
+					return
+				default:
+					tm.UpdateTracker(position(id, x, 0, 0))
+				}
+			}
+		}(i)
+	}
+	return func() { close(done); wg.Wait() }
+}
+
+// within fails t unless fn returns within d.
+func within(t *testing.T, d time.Duration, what string, fn func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		fn()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(d):
+		t.Fatalf("%s did not finish within %v", what, d)
+	}
+}
+
+func TestShutdownUnderLoad(t *testing.T) {
+	before := runtime.NumGoroutine()
+	cfg := DefaultConfig()
+	cfg.UpdateBufferSize, cfg.ForwardBufferSize = 64, 64
+	tm := NewTrackerManager(cfg.UpdateBufferSize, cfg.ForwardBufferSize)
+	tm.Configure(cfg)
+	tm.StartSweeper(time.Second, 10*time.Millisecond)
+	f, senders := newTestForwarder(testConfig())
+	forwardDone := make(chan struct{})
+	go func() {
+		f.forwardUpdatedData(context.Background(), tm.forwardCh)
+		close(forwardDone)
+	}()
+
+	stopLoad := load(tm)
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	within(t, 3*time.Second, "Shutdown", func() { tm.Shutdown(ctx) })
+	within(t, 3*time.Second, "forwarder", func() { <-forwardDone })
+	stopLoad() // late updates are ignored
+
+	if len(senders[0].messages()) == 0 {
+		t.Error("nothing was forwarded")
+	}
+	checkGoroutines(t, before)
+}
+
+// blockingSender blocks every send until release is closed.
+type blockingSender struct {
+	release chan struct{}
+	sent    chan struct{} // closed on the first send
+	once    sync.Once
+	count   int
+}
+
+func (s *blockingSender) Send(osc.Packet) error {
+	s.once.Do(func() { close(s.sent) })
+	<-s.release
+	s.count++
+	return nil
+}
+
+func TestShutdownAbandonsStuckForwarder(t *testing.T) {
+	before := runtime.NumGoroutine()
+	cfg := DefaultConfig()
+	cfg.UpdateBufferSize, cfg.ForwardBufferSize = 64, 64
+	tm := NewTrackerManager(cfg.UpdateBufferSize, cfg.ForwardBufferSize)
+	tm.Configure(cfg)
+	f, _ := newTestForwarder(testConfig())
+	sender := &blockingSender{release: make(chan struct{}), sent: make(chan struct{})}
+	f.dests[0].sender = sender
+	forwardCtx, stopForwarding := context.WithCancel(context.Background())
+	forwardDone := make(chan struct{})
+	go func() {
+		f.forwardUpdatedData(forwardCtx, tm.forwardCh)
+		close(forwardDone)
+	}()
+
+	stopLoad := load(tm)
+	<-sender.sent
+	for len(tm.forwardCh) < cap(tm.forwardCh) {
+		time.Sleep(time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	within(t, time.Second, "Shutdown with a stuck forwarder", func() { tm.Shutdown(ctx) })
+	stopLoad()
+
+	stopForwarding()
+	close(sender.release)
+	within(t, time.Second, "stopped forwarder", func() { <-forwardDone })
+	if sender.count >= cap(tm.forwardCh) {
+		t.Errorf("stopped forwarder still sent %d packets", sender.count)
+	}
+	checkGoroutines(t, before)
+}
+
+func TestOfferUnbuffered(t *testing.T) {
+	dropped := func() uint64 {
+		_, counts := metrics.Dropped.snapshot()
+		return counts["test"]
+	}
+	ch := make(chan TrackerData)
+	for _, dropOldest := range []bool{false, true} {
+		before := dropped()
+		within(t, time.Second, "offer without a receiver", func() {
+			offer(ch, position(1, 1, 2, 3), dropOldest, "test")
+		})
+		if n := dropped() - before; n != 1 {
+			t.Errorf("dropOldest=%v: counted %d drops, want 1", dropOldest, n)
+		}
+	}
+
+	got := make(chan TrackerData)
+	go func() { got <- <-ch }()
+	// offer only hands over once the receiver is waiting
+	for before := dropped(); ; {
+		offer(ch, position(1, 4, 5, 6), false, "test")
+		if dropped() == before {
+			break
+		}
+		before = dropped()
+		runtime.Gosched()
+	}
+	if data := <-got; data.Position != [3]float32{4, 5, 6} {
+		t.Errorf("receiver got %v, want the offered update", data.Position)
+	}
+}
+
+func TestUnbufferedShutdown(t *testing.T) {
+	before := runtime.NumGoroutine()
+	cfg := DefaultConfig()
+	cfg.UpdateBufferSize, cfg.ForwardBufferSize = 0, 0
+	tm := NewTrackerManager(cfg.UpdateBufferSize, cfg.ForwardBufferSize)
+	tm.Configure(cfg)
+	f, senders := newTestForwarder(testConfig())
+	forwardDone := make(chan struct{})
+	go func() {
+		f.forwardUpdatedData(context.Background(), tm.forwardCh)
+		close(forwardDone)
+	}()
+
+	stopLoad := load(tm)
+	time.Sleep(50 * time.Millisecond)
+	within(t, 3*time.Second, "Shutdown", func() { tm.Shutdown(context.Background()) })
+	within(t, 3*time.Second, "forwarder", func() { <-forwardDone })
+	stopLoad()
+
+	if len(senders[0].messages()) == 0 {
+		t.Error("nothing was forwarded through the unbuffered queues")
+	}
+	checkGoroutines(t, before)
+}
+
+func TestPerTrackerSmoothing(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.SmoothingFactor = 0.9
+	cfg.SmoothingFactors = map[int]float64{1: 0}
+	tm := newTestManager(t, cfg)
+	for _, id := range []int{1, 2} {
+		tm.process(position(id, 0, 0, 0))
+		tm.process(position(id, 10, 0, 0))
+	}
+
+	last := map[int]float32{}
+	for _, data := range forwarded(tm) {
+		last[data.ID] = data.Position[0]
+	}
+	if last[1] != 10 {
+		t.Errorf("tracker 1 forwarded at x=%v, want 10 with smoothing off", last[1])
+	}
+	if math.Abs(float64(last[2]-1)) > 1e-5 {
+		t.Errorf("tracker 2 forwarded at x=%v, want 1 with the global factor", last[2])
+	}
+}
+
+func TestEvictionTellsForwarder(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxTrackers = 1
+	cfg.TrackerLimit = LimitEvict
+	tm := newTestManager(t, cfg)
+
+	tm.process(position(1, 1, 1, 1))
+	tm.process(position(2, 2, 2, 2))
+
+	out := forwarded(tm)
+	if len(out) != 3 {
+		t.Fatalf("forwarded %d updates, want 3: %+v", len(out), out)
+	}
+	if !out[1].Removed || out[1].ID != 1 {
+		t.Errorf("second entry %+v, want the removal notice of tracker 1", out[1])
+	}
+	if out[2].Removed || out[2].ID != 2 {
+		t.Errorf("third entry %+v, want the update of tracker 2", out[2])
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	tm := newTestManager(t, DefaultConfig())
+	tm.process(position(1, 1, 1, 1))
+	forwarded(tm)
+	tracker, _ := tm.GetTrackerData("", 1)
+
+	// updated since the sweep, so it stays
+	tm.process(TrackerData{ID: 1, LastSeen: tracker.LastSeen.Add(-time.Second), Removed: true})
+	if _, exists := tm.GetTrackerData("", 1); !exists {
+		t.Fatal("tracker updated after the sweep was removed")
+	}
+
+	tm.process(TrackerData{ID: 1, LastSeen: tracker.LastSeen, Removed: true})
+	if _, exists := tm.GetTrackerData("", 1); exists {
+		t.Fatal("stale tracker was not removed")
+	}
+	if out := forwarded(tm); len(out) != 1 || !out[0].Removed {
+		t.Errorf("forwarded %+v, want one removal notice", out)
+	}
+}
+
+func TestRemovalNoticesWaitForRoom(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ForwardBufferSize = 1
+	cfg.MaxTrackers = 1
+	cfg.TrackerLimit = LimitEvict
+	tm := newTestManager(t, cfg)
+
+	tm.process(position(1, 1, 1, 1)) // fills forwardCh
+	tm.process(position(2, 2, 2, 2)) // evicts 1, no room for the notice
+	if len(tm.removals) != 1 {
+		t.Fatalf("%d notices pending, want 1", len(tm.removals))
+	}
+	forwarded(tm)
+	tm.process(position(2, 3, 3, 3))
+	if out := forwarded(tm); len(out) != 1 || !out[0].Removed || out[0].ID != 1 {
+		t.Errorf("forwarded %+v, want the pending notice of tracker 1 first", out)
+	}
+}
+
+func TestShutdownDrainTimeout(t *testing.T) {
+	tm := NewTrackerManager(100, 100)
+	tm.Configure(DefaultConfig())
+	// processUpdates takes one update and blocks on mu, the rest stay queued
+	tm.mu.Lock()
+	for i := 0; i < 100; i++ {
+		tm.UpdateTracker(position(1, float32(i), 0, 0))
+	}
+	for len(tm.updateCh) == 100 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	var drained, dropped int
+	done := make(chan struct{})
+	go func() {
+		drained, dropped = tm.Shutdown(ctx)
+		close(done)
+	}()
+	<-tm.abandon // the drain timeout passed
+	tm.mu.Unlock()
+	within(t, time.Second, "Shutdown after the drain timeout", func() { <-done })
+
+	if drained+dropped != 99 {
+		t.Errorf("drained %d and dropped %d, want 99 together", drained, dropped)
+	}
+	if dropped == 0 {
+		t.Error("nothing dropped after the drain timeout")
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// metrics are the process wide counters, served in Prometheus text format at
+// /metrics on the debug server.
+var metrics = &Metrics{
+	Started:      time.Now(),
+	Forwarded:    newLabeledCounter(),
+	SendErrors:   newLabeledCounter(),
+	Skipped:      newLabeledCounter(),
+	Dropped:      newLabeledCounter(),
+	NonFinite:    newLabeledCounter(),
+	OutOfBounds:  newLabeledCounter(),
+	TrackerLimit: newLabeledCounter(),
+
+	ParseFailures: newLabeledCounter(),
+
+	DestinationDown: newLabeledCounter(),
+
+	Latency: newHistogram(latencyBuckets),
+}
+
+type Metrics struct {
+	Started time.Time // process start, for the uptime
+
+	Received atomic.Uint64 // every OSC message handed to the dispatcher
+	Parsed   atomic.Uint64 // tracker updates parsed successfully
+	Freezes  atomic.Uint64 // trackers detected as frozen
+	Late     atomic.Uint64 // updates dropped by the jitter buffer, a newer one was already processed
+
+	ListenerRestarts atomic.Uint64 // listeners reopened by the watchdog
+
+	Forwarded    *labeledCounter // packets sent, by destination
+	SendErrors   *labeledCounter // failed sends, by destination
+	Skipped      *labeledCounter // packets not sent because the destination is down, by destination
+	Dropped      *labeledCounter // updates dropped because a queue was full, by queue
+	NonFinite    *labeledCounter // updates with NaN/Inf values, by action (rejected or clamped)
+	OutOfBounds  *labeledCounter // positions outside the configured bounds, by action (clamped or dropped)
+	TrackerLimit *labeledCounter // new trackers beyond max_trackers, by action (rejected or evicted)
+
+	ParseFailures *labeledCounter // tracking messages that failed to parse, by reason, see parseError
+
+	DestinationDown *labeledCounter // gauge, 1 while a destination is marked down, by destination
+
+	Latency *histogram // seconds from the source timetag to sending, messages without one are not observed
+
+	UpdateSaturated  atomic.Bool // the update queue is above the high-water mark
+	ForwardSaturated atomic.Bool // same for the forward queue
+}
+
+// health tracks the pipeline state behind /healthz and /readyz. It is kept
+// separate from the tracker state so the probes never take the tracker lock.
+var health = &Health{}
+
+type Health struct {
+	Listening  atomic.Bool // the OSC listener (or replay) is running
+	Forwarding atomic.Bool // the forwarder goroutine is running
+	Sent       atomic.Bool // a destination accepted a send, or there are none
+}
+
+// labeledCounter is a set of counters keyed by a single label value. With Set
+// it doubles as a gauge.
+type labeledCounter struct {
+	mu     sync.Mutex
+	values map[string]uint64
+}
+
+func newLabeledCounter() *labeledCounter {
+	return &labeledCounter{values: make(map[string]uint64)}
+}
+
+func (c *labeledCounter) Inc(label string) {
+	c.mu.Lock()
+	c.values[label]++
+	c.mu.Unlock()
+}
+
+func (c *labeledCounter) Set(label string, v uint64) {
+	c.mu.Lock()
+	c.values[label] = v
+	c.mu.Unlock()
+}
+
+// total returns the sum over all labels.
+func (c *labeledCounter) total() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var sum uint64
+	for _, v := range c.values {
+		sum += v
+	}
+	return sum
+}
+
+// snapshot returns the label values in sorted order along with their counts.
+func (c *labeledCounter) snapshot() ([]string, map[string]uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	labels := make([]string, 0, len(c.values))
+	values := make(map[string]uint64, len(c.values))
+	for label, v := range c.values {
+		labels = append(labels, label)
+		values[label] = v
+	}
+	sort.Strings(labels)
+	return labels, values
+}
+
+// latencyBuckets are the upper bounds in seconds of the latency histogram
+var latencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+// histogram counts observations into cumulative buckets like a Prometheus
+// histogram.
+type histogram struct {
+	mu      sync.Mutex
+	bounds  []float64
+	buckets []uint64 // observations <= bounds[i], not cumulative until written
+	count   uint64
+	sum     float64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, buckets: make([]uint64, len(bounds))}
+}
+
+func (h *histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.buckets[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// Status is the summary served at /status.
+type Status struct {
+	Uptime         string                       `json:"uptime"`
+	Received       uint64                       `json:"received"`
+	Parsed         uint64                       `json:"parsed"`
+	ParseFailures  uint64                       `json:"parse_failures"`
+	ActiveTrackers int                          `json:"active_trackers"`
+	Destinations   map[string]DestinationStatus `json:"destinations"`
+	Queues         map[string]QueueStatus       `json:"queues"`
+}
+
+type DestinationStatus struct {
+	Up      bool   `json:"up"`
+	Sent    uint64 `json:"sent"`
+	Errors  uint64 `json:"errors"`
+	Skipped uint64 `json:"skipped"`
+}
+
+type QueueStatus struct {
+	Depth    int    `json:"depth"`
+	Capacity int    `json:"capacity"`
+	Dropped  uint64 `json:"dropped"`
+}
+
+// Status collects the counters and the gauges read from tm into one summary.
+func (m *Metrics) Status(tm *TrackerManager) Status {
+	_, sent := m.Forwarded.snapshot()
+	_, errs := m.SendErrors.snapshot()
+	_, skipped := m.Skipped.snapshot()
+	_, down := m.DestinationDown.snapshot()
+	_, dropped := m.Dropped.snapshot()
+
+	destinations := make(map[string]DestinationStatus)
+	for _, counts := range []map[string]uint64{sent, errs, skipped, down} {
+		for addr := range counts {
+			destinations[addr] = DestinationStatus{
+				Up:      down[addr] == 0,
+				Sent:    sent[addr],
+				Errors:  errs[addr],
+				Skipped: skipped[addr],
+			}
+		}
+	}
+
+	return Status{
+		Uptime:         time.Since(m.Started).Round(time.Second).String(),
+		Received:       m.Received.Load(),
+		Parsed:         m.Parsed.Load(),
+		ParseFailures:  m.ParseFailures.total(),
+		ActiveTrackers: tm.ActiveCount(),
+		Destinations:   destinations,
+		Queues: map[string]QueueStatus{
+			"update":  {Depth: len(tm.updateCh), Capacity: cap(tm.updateCh), Dropped: dropped["update"]},
+			"forward": {Depth: len(tm.forwardCh), Capacity: cap(tm.forwardCh), Dropped: dropped["forward"]},
+		},
+	}
+}
+
+// WritePrometheus writes all metrics plus the gauges read from tm.
+func (m *Metrics) WritePrometheus(w io.Writer, tm *TrackerManager) {
+	writeMetric(w, "oscwrench_received_total", "counter", "OSC messages received.", m.Received.Load())
+	writeMetric(w, "oscwrench_parsed_total", "counter", "Tracker updates parsed.", m.Parsed.Load())
+	writeLabeledMetric(w, "oscwrench_parse_failures_total", "counter", "Tracking messages that failed to parse.", "reason", m.ParseFailures)
+	writeLabeledMetric(w, "oscwrench_forwarded_total", "counter", "OSC packets forwarded.", "destination", m.Forwarded)
+	writeLabeledMetric(w, "oscwrench_send_errors_total", "counter", "Failed sends.", "destination", m.SendErrors)
+	writeLabeledMetric(w, "oscwrench_skipped_total", "counter", "Packets not sent because the destination is down.", "destination", m.Skipped)
+	writeLabeledMetric(w, "oscwrench_destination_down", "gauge", "Whether a destination is marked down after repeated failures.", "destination", m.DestinationDown)
+	writeLabeledMetric(w, "oscwrench_non_finite_total", "counter", "Updates carrying NaN or Inf values.", "action", m.NonFinite)
+	writeLabeledMetric(w, "oscwrench_out_of_bounds_total", "counter", "Positions outside the configured bounds.", "action", m.OutOfBounds)
+	writeLabeledMetric(w, "oscwrench_tracker_limit_total", "counter", "New trackers beyond max_trackers.", "action", m.TrackerLimit)
+	writeLabeledMetric(w, "oscwrench_dropped_total", "counter", "Updates dropped because a queue was full.", "queue", m.Dropped)
+
+	writeHistogram(w, "oscwrench_latency_seconds", "Time from the source timetag to forwarding, for messages that carry one.", m.Latency)
+
+	writeMetric(w, "oscwrench_late_total", "counter", "Updates dropped by the jitter buffer for arriving after a newer one.", m.Late.Load())
+	writeMetric(w, "oscwrench_freezes_total", "counter", "Trackers detected as frozen.", m.Freezes.Load())
+	writeMetric(w, "oscwrench_listener_restarts_total", "counter", "Listeners reopened by the watchdog after a silence.", m.ListenerRestarts.Load())
+
+	writeMetric(w, "oscwrench_active_trackers", "gauge", "Trackers that have not expired.", tm.ActiveCount())
+	writeMetric(w, "oscwrench_frozen_trackers", "gauge", "Trackers currently frozen.", tm.FrozenCount())
+	fmt.Fprintf(w, "# HELP oscwrench_queue_depth Items waiting in each queue.\n# TYPE oscwrench_queue_depth gauge\n")
+	fmt.Fprintf(w, "oscwrench_queue_depth{queue=\"update\"} %d\n", len(tm.updateCh))
+	fmt.Fprintf(w, "oscwrench_queue_depth{queue=\"forward\"} %d\n", len(tm.forwardCh))
+	fmt.Fprintf(w, "# HELP oscwrench_tracker_rate_hz Incoming updates per second of each tracker.\n# TYPE oscwrench_tracker_rate_hz gauge\n")
+	for _, tracker := range tm.GetAllTrackers() {
+		if tracker.Source != "" {
+			fmt.Fprintf(w, "oscwrench_tracker_rate_hz{source=%q,tracker=\"%d\"} %g\n", tracker.Source, tracker.ID, tracker.Rate)
+		} else {
+			fmt.Fprintf(w, "oscwrench_tracker_rate_hz{tracker=\"%d\"} %g\n", tracker.ID, tracker.Rate)
+		}
+	}
+	fmt.Fprintf(w, "# HELP oscwrench_queue_saturated Whether a queue is above the high-water mark.\n# TYPE oscwrench_queue_saturated gauge\n")
+	fmt.Fprintf(w, "oscwrench_queue_saturated{queue=\"update\"} %d\n", boolGauge(m.UpdateSaturated.Load()))
+	fmt.Fprintf(w, "oscwrench_queue_saturated{queue=\"forward\"} %d\n", boolGauge(m.ForwardSaturated.Load()))
+}
+
+func boolGauge(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func writeMetric(w io.Writer, name, kind, help string, value any) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, value)
+}
+
+func writeLabeledMetric(w io.Writer, name, kind, help, label string, c *labeledCounter) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	labels, values := c.snapshot()
+	for _, l := range labels {
+		fmt.Fprintf(w, "%s{%s=%q} %d\n", name, label, l, values[l])
+	}
+}
+
+func writeHistogram(w io.Writer, name, help string, h *histogram) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.buckets[i]
+		fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", name, bound, cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n%s_sum %g\n%s_count %d\n", name, h.count, name, h.sum, name, h.count)
+}
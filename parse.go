@@ -0,0 +1,327 @@
+package main
+
+import (
+	"github.com/crgimenes/go-osc"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Matches reports whether address is inside the schema namespace, or with
+// handlers configured whether it matches one of their patterns.
+func (s *Schema) Matches(address string) bool {
+	address = normalizeAddress(address)
+	if len(s.Handlers) > 0 {
+		return s.handlerKind(address) != ""
+	}
+	return strings.HasPrefix(address, s.prefix())
+}
+
+// handlerKind returns the kind of the first handler whose pattern matches
+// address, "" when none does.
+func (s *Schema) handlerKind(address string) string {
+	for _, h := range s.Handlers {
+		if matchPattern(h.Pattern, address) {
+			return h.Kind
+		}
+	}
+	return ""
+}
+
+// trackerAddress is a tracker message address split at the ID segment.
+type trackerAddress struct {
+	id      int
+	subpath []string // the parts after the ID, or after the namespace with id_arg, never empty without handlers
+	kind    string   // of the handler the address matched, "" without handlers
+}
+
+// route checks that the normalized address belongs to the schema and splits
+// it into the tracker ID and the subpath the field parsers work on. With
+// id_arg the ID is left for parseMessage to take from the arguments.
+func (s *Schema) route(address string) (trackerAddress, parseError) {
+	var kind string
+	if len(s.Handlers) > 0 {
+		if kind = s.handlerKind(address); kind == "" {
+			return trackerAddress{}, parseNamespace
+		}
+	} else if !strings.HasPrefix(address, s.prefix()) {
+		return trackerAddress{}, parseNamespace
+	}
+
+	parts := strings.Split(address, "/")
+	if s.IDArg >= 0 {
+		if len(s.Handlers) > 0 {
+			return trackerAddress{kind: kind}, parseOK
+		}
+		// the prefix ends in '/', so at least one segment follows it
+		return trackerAddress{subpath: parts[len(s.Namespace)+1:]}, parseOK
+	}
+
+	// the ID must be followed by at least one field segment
+	if len(parts) <= s.IDIndex+1 {
+		return trackerAddress{}, parseAddress
+	}
+	id, err := strconv.Atoi(parts[s.IDIndex])
+	if err != nil {
+		return trackerAddress{}, parseID
+	}
+	return trackerAddress{id: id, subpath: parts[s.IDIndex+1:], kind: kind}, parseOK
+}
+
+// kindOf returns what a message to a with n arguments carries: the kind of
+// its handler, or else the one named by the field keywords of the subpath.
+func (s *Schema) kindOf(a trackerAddress, n int) string {
+	if a.kind != "" {
+		return a.kind
+	}
+	if s.Active != "" && slices.Contains(a.subpath, s.Active) {
+		return KindActive
+	}
+	return s.keywordKind(a.subpath, n)
+}
+
+// keywordKind finds the kind of an update with n arguments by looking for
+// the field keywords in the address parts after the ID.
+func (s *Schema) keywordKind(fieldParts []string, n int) string {
+	field := strings.Join(fieldParts, "/")
+	switch {
+	case s.Pose != "" && slices.Contains(fieldParts, s.Pose):
+		return KindPose
+	case strings.Contains(field, s.Position) && n == 3:
+		return KindPosition
+	case strings.Contains(field, s.Rotation):
+		return KindRotation
+	}
+	return ""
+}
+
+// normalizeAddress collapses repeated slashes and drops a trailing one, so
+// "//tracking/trackers/3//position/" reads as "/tracking/trackers/3/position".
+func normalizeAddress(address string) string {
+	for strings.Contains(address, "//") {
+		address = strings.ReplaceAll(address, "//", "/")
+	}
+	if len(address) > 1 {
+		address = strings.TrimSuffix(address, "/")
+	}
+	return address
+}
+
+func (s *Schema) prefix() string {
+	if len(s.Namespace) == 0 {
+		return "/"
+	}
+	return "/" + strings.Join(s.Namespace, "/") + "/"
+}
+
+// parseError is why parseMessage rejected a message. It is a plain value
+// rather than an error so failing messages cost no allocation.
+type parseError uint8
+
+const (
+	parseOK        parseError = iota
+	parseNamespace            // outside the namespace or matching no handler
+	parseAddress              // no ID segment, or nothing after it
+	parseID                   // the ID segment is not a number
+	parseArgCount             // no field takes this many arguments
+	parseArgType              // an argument has the wrong type
+	parseNonFinite            // NaN or Inf, with non_finite: reject
+	parseField                // no field keyword, or the field takes a different number of arguments
+)
+
+// String is the reason label of the parse failure metric.
+func (e parseError) String() string {
+	switch e {
+	case parseOK:
+		return "ok"
+	case parseNamespace:
+		return "namespace"
+	case parseAddress:
+		return "address"
+	case parseID:
+		return "id"
+	case parseArgCount:
+		return "arg_count"
+	case parseArgType:
+		return "arg_type"
+	case parseNonFinite:
+		return "non_finite"
+	case parseField:
+		return "field"
+	}
+	return "unknown"
+}
+
+// parseMessage turns a tracker message into an update, or tells why it
+// cannot. Messages carrying NaN or Inf are rejected, unless keepNonFinite is
+// set, in which case they are left for processUpdates to clamp.
+func parseMessage(msg *osc.Message, schema *Schema, keepNonFinite bool) (TrackerData, parseError) {
+	// empty segments would shift the indices and hide the field keyword
+	a, perr := schema.route(normalizeAddress(msg.Address))
+	if perr != parseOK {
+		return TrackerData{}, perr
+	}
+
+	args := msg.Arguments
+	var rest [maxValueArgs]any // the arguments without the ID, kept off the heap
+	if schema.IDArg >= 0 {
+		if len(args) <= schema.IDArg || len(args) > maxValueArgs+1 {
+			return TrackerData{}, parseArgCount
+		}
+		id, ok := toInt(args[schema.IDArg])
+		if !ok {
+			return TrackerData{}, parseID
+		}
+		a.id = id
+		n := copy(rest[:], args[:schema.IDArg])
+		n += copy(rest[n:], args[schema.IDArg+1:])
+		args = rest[:n]
+	}
+
+	n := len(args)
+	kind := schema.kindOf(a, n)
+	if kind == KindActive {
+		data, perr := parseActive(args)
+		if perr != parseOK {
+			return TrackerData{}, perr
+		}
+		data.ID = a.id
+		return data, parseOK
+	}
+
+	if n != 3 && n != 4 && n != 6 {
+		return TrackerData{}, parseArgCount
+	}
+	values := [6]float32{}
+	for i := 0; i < n; i++ {
+		if v, ok := toFloat32(args[i]); ok {
+			values[i] = v
+		} else {
+			return TrackerData{}, parseArgType
+		}
+	}
+	if !keepNonFinite && !finite(values[:n]) {
+		metrics.NonFinite.Inc("rejected")
+		return TrackerData{}, parseNonFinite
+	}
+
+	parse, exists := valueParsers[kind]
+	if !exists {
+		return TrackerData{}, parseField
+	}
+	data, ok := parse(values[:n], schema)
+	if !ok {
+		return TrackerData{}, parseField
+	}
+	data.ID = a.id
+	return data, parseOK
+}
+
+// maxValueArgs is the most arguments an update carries besides the ID, a pose
+const maxValueArgs = 6
+
+// valueParsers build the update of each kind from its float arguments, ok is
+// false when the kind does not take that many.
+var valueParsers = map[string]func(values []float32, schema *Schema) (data TrackerData, ok bool){
+	KindPosition: parsePosition,
+	KindRotation: parseRotation,
+	KindPose:     parsePose,
+}
+
+// parsePosition takes 3 floats, x,y,z.
+func parsePosition(values []float32, schema *Schema) (TrackerData, bool) {
+	if len(values) != 3 {
+		return TrackerData{}, false
+	}
+	return TrackerData{Position: reorder(values, schema.PositionOrder), Fields: FieldPosition}, true
+}
+
+// parseRotation takes either 3 floats as Euler angles in degrees or 4 floats
+// as a quaternion (x,y,z,w).
+func parseRotation(values []float32, schema *Schema) (TrackerData, bool) {
+	switch len(values) {
+	case 3:
+		return TrackerData{Rotation: reorder(values, schema.RotationOrder), Fields: FieldRotation}, true
+	case 4:
+		return TrackerData{Quaternion: [4]float32(values), Fields: FieldQuaternion}, true
+	}
+	return TrackerData{}, false
+}
+
+// parsePose takes 6 floats, x,y,z,pitch,yaw,roll.
+func parsePose(values []float32, schema *Schema) (TrackerData, bool) {
+	if len(values) != 6 {
+		return TrackerData{}, false
+	}
+	return TrackerData{
+		Position: reorder(values[0:3], schema.PositionOrder),
+		Rotation: reorder(values[3:6], schema.RotationOrder),
+		Fields:   FieldPosition | FieldRotation,
+	}, true
+}
+
+// parseActive takes a single T or F, the tracker's status. I (impulse) never
+// gets here, the OSC decoder rejects the whole packet.
+func parseActive(args []any) (TrackerData, parseError) {
+	if len(args) != 1 {
+		return TrackerData{}, parseArgCount
+	}
+	var active bool
+	switch v := args[0].(type) {
+	case bool:
+		active = v
+	case nil:
+		// N says nothing about whether the tracker is tracking, so the last
+		// status is kept
+		return TrackerData{}, parseArgType
+	default:
+		return TrackerData{}, parseArgType
+	}
+	return TrackerData{Inactive: !active, Fields: FieldActive}, parseOK
+}
+
+// reorder picks component i from args[order[i]], an empty order keeps args
+// as they are.
+func reorder(args []float32, order []int) [3]float32 {
+	if len(order) == 0 {
+		return [3]float32{args[0], args[1], args[2]}
+	}
+	return [3]float32{args[order[0]], args[order[1]], args[order[2]]}
+}
+
+// toFloat32 converts the numeric OSC argument types (f, d, i, h) to float32.
+func toFloat32(arg any) (float32, bool) {
+	switch v := arg.(type) {
+	case float32:
+		return v, true
+	case float64:
+		return float32(v), true
+	case int32:
+		return float32(v), true
+	case int64:
+		return float32(v), true
+	}
+	return 0, false
+}
+
+// toInt converts the integer OSC argument types (i, h) to an ID.
+func toInt(arg any) (int, bool) {
+	switch v := arg.(type) {
+	case int32:
+		return int(v), true
+	case int64:
+		return int(v), true
+	}
+	return 0, false
+}
+
+// finite reports whether none of values is NaN or Inf.
+func finite(values []float32) bool {
+	for _, v := range values {
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			return false
+		}
+	}
+	return true
+}
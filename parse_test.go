@@ -0,0 +1,302 @@
+package main
+
+import (
+	"github.com/crgimenes/go-osc"
+	"math"
+	"net"
+	"testing"
+)
+
+// parseCase is a message and what parseMessage should make of it. schema
+// adjusts the default schema, keep is keepNonFinite.
+type parseCase struct {
+	name    string
+	address string
+	args    []any
+	schema  func(s *Schema)
+	keep    bool
+	want    parseError
+	data    TrackerData // compared when want is parseOK
+}
+
+func runParseCases(t *testing.T, cases []parseCase) {
+	t.Helper()
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			schema := DefaultConfig().Schema
+			if c.schema != nil {
+				c.schema(&schema)
+			}
+			data, perr := parseMessage(osc.NewMessage(c.address, c.args...), &schema, c.keep)
+			if perr != c.want {
+				t.Fatalf("parseMessage(%s %v) failed with %v, want %v", c.address, c.args, perr, c.want)
+			}
+			if perr == parseOK && data != c.data {
+				t.Errorf("parseMessage(%s %v) = %+v, want %+v", c.address, c.args, data, c.data)
+			}
+		})
+	}
+}
+
+func floats(values ...float32) []any {
+	args := make([]any, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	return args
+}
+
+func TestParsePose(t *testing.T) {
+	pose := TrackerData{ID: 2, Position: [3]float32{1, 2, 3}, Rotation: [3]float32{-90, 45, 180}, Fields: FieldPosition | FieldRotation}
+	runParseCases(t, []parseCase{
+		{name: "pose", address: "/tracking/trackers/2/pose", args: floats(1, 2, 3, -90, 45, 180), data: pose},
+		{name: "pose of doubles", address: "/tracking/trackers/2/pose", args: []any{1.0, 2.0, 3.0, -90.0, 45.0, 180.0}, data: pose},
+		{name: "pose with 4 arguments", address: "/tracking/trackers/2/pose", args: floats(1, 2, 3, 4), want: parseField},
+		{name: "pose with 5 arguments", address: "/tracking/trackers/2/pose", args: floats(1, 2, 3, 4, 5), want: parseArgCount},
+		{name: "pose with 7 arguments", address: "/tracking/trackers/2/pose", args: floats(1, 2, 3, 4, 5, 6, 7), want: parseArgCount},
+		{name: "position with 6 arguments", address: "/tracking/trackers/2/position", args: floats(1, 2, 3, 4, 5, 6), want: parseField},
+		{name: "rotation with 6 arguments", address: "/tracking/trackers/2/rotation", args: floats(1, 2, 3, 4, 5, 6), want: parseField},
+		{name: "pose disabled", address: "/tracking/trackers/2/pose", args: floats(1, 2, 3, 4, 5, 6),
+			schema: func(s *Schema) { s.Pose = "" }, want: parseField},
+		{name: "renamed pose", address: "/tracking/trackers/2/transform", args: floats(1, 2, 3, -90, 45, 180),
+			schema: func(s *Schema) { s.Pose = "transform" }, data: pose},
+	})
+}
+
+func TestParseAlternateSchemas(t *testing.T) {
+	position := func(id int) TrackerData {
+		return TrackerData{ID: id, Position: [3]float32{1, 2, 3}, Fields: FieldPosition}
+	}
+	short := func(s *Schema) {
+		s.Namespace, s.IDIndex, s.Position, s.Rotation = []string{"vr"}, 2, "pos", "rot"
+	}
+	runParseCases(t, []parseCase{
+		{name: "short namespace and keywords", address: "/vr/4/pos", args: floats(1, 2, 3), schema: short, data: position(4)},
+		{name: "renamed rotation", address: "/vr/4/rot", args: floats(10, 20, 30), schema: short,
+			data: TrackerData{ID: 4, Rotation: [3]float32{10, 20, 30}, Fields: FieldRotation}},
+		{name: "default address under a short schema", address: "/tracking/trackers/4/position", args: floats(1, 2, 3), schema: short, want: parseNamespace},
+		{name: "no namespace", address: "/5/position", args: floats(1, 2, 3),
+			schema: func(s *Schema) { s.Namespace, s.IDIndex = nil, 1 }, data: position(5)},
+		{name: "ID after a free segment", address: "/tracking/trackers/left/6/position", args: floats(1, 2, 3),
+			schema: func(s *Schema) { s.IDIndex = 4 }, data: position(6)},
+		{name: "keyword inside a longer segment", address: "/tracking/trackers/7/localposition", args: floats(1, 2, 3), data: position(7)},
+		{name: "handler", address: "/body/8/head", args: floats(1, 2, 3),
+			schema: func(s *Schema) {
+				s.IDIndex = 2
+				s.Handlers = []Handler{{Pattern: "/body/*/head", Kind: KindPosition}}
+			},
+			data: position(8)},
+		{name: "no handler matches", address: "/body/8/hand", args: floats(1, 2, 3),
+			schema: func(s *Schema) {
+				s.IDIndex = 2
+				s.Handlers = []Handler{{Pattern: "/body/*/head", Kind: KindPosition}}
+			},
+			want: parseNamespace},
+	})
+}
+
+func TestParseNumericArguments(t *testing.T) {
+	runParseCases(t, []parseCase{
+		{name: "mixed types", address: "/tracking/trackers/1/position", args: []any{float32(1.5), 2.25, int32(-3)},
+			data: TrackerData{ID: 1, Position: [3]float32{1.5, 2.25, -3}, Fields: FieldPosition}},
+		{name: "int64", address: "/tracking/trackers/1/position", args: []any{int64(1 << 40), int64(0), int64(-7)},
+			data: TrackerData{ID: 1, Position: [3]float32{1 << 40, 0, -7}, Fields: FieldPosition}},
+		{name: "string", address: "/tracking/trackers/1/position", args: []any{1.0, "2", 3.0}, want: parseArgType},
+		{name: "blob", address: "/tracking/trackers/1/position", args: []any{1.0, []byte{2}, 3.0}, want: parseArgType},
+		{name: "boolean", address: "/tracking/trackers/1/position", args: []any{1.0, true, 3.0}, want: parseArgType},
+		{name: "nil", address: "/tracking/trackers/1/position", args: []any{1.0, nil, 3.0}, want: parseArgType},
+	})
+}
+
+func TestToFloat32Precision(t *testing.T) {
+	for _, v := range []float64{0.1, 1.0000001234, -123.456789, 1e-7, 98765.4321} {
+		got, ok := toFloat32(v)
+		if !ok {
+			t.Fatalf("toFloat32(%v) rejected a float64", v)
+		}
+		// float32 keeps 24 bits of mantissa
+		if diff := math.Abs(float64(got) - v); diff > math.Abs(v)*math.Pow(2, -24) {
+			t.Errorf("toFloat32(%v) = %v, off by %v", v, got, diff)
+		}
+	}
+}
+
+func TestParseMessage(t *testing.T) {
+	inf := float32(math.Inf(1))
+	runParseCases(t, []parseCase{
+		{name: "position", address: "/tracking/trackers/3/position", args: floats(1, 2, 3),
+			data: TrackerData{ID: 3, Position: [3]float32{1, 2, 3}, Fields: FieldPosition}},
+		{name: "rotation", address: "/tracking/trackers/3/rotation", args: floats(10, 20, 30),
+			data: TrackerData{ID: 3, Rotation: [3]float32{10, 20, 30}, Fields: FieldRotation}},
+		{name: "quaternion", address: "/tracking/trackers/3/rotation", args: floats(0, 0, 0, 1),
+			data: TrackerData{ID: 3, Quaternion: [4]float32{0, 0, 0, 1}, Fields: FieldQuaternion}},
+		{name: "pose", address: "/tracking/trackers/3/pose", args: floats(1, 2, 3, 10, 20, 30),
+			data: TrackerData{ID: 3, Position: [3]float32{1, 2, 3}, Rotation: [3]float32{10, 20, 30}, Fields: FieldPosition | FieldRotation}},
+		{name: "active", address: "/tracking/trackers/3/active", args: []any{true},
+			data: TrackerData{ID: 3, Fields: FieldActive}},
+		{name: "inactive", address: "/tracking/trackers/3/active", args: []any{false},
+			data: TrackerData{ID: 3, Inactive: true, Fields: FieldActive}},
+		{name: "doubled and trailing slashes", address: "//tracking//trackers/3//position/", args: floats(1, 2, 3),
+			data: TrackerData{ID: 3, Position: [3]float32{1, 2, 3}, Fields: FieldPosition}},
+
+		{name: "outside the namespace", address: "/other/trackers/3/position", args: floats(1, 2, 3), want: parseNamespace},
+		{name: "no field segment", address: "/tracking/trackers/3", args: floats(1, 2, 3), want: parseAddress},
+		{name: "only a trailing slash after the ID", address: "/tracking/trackers/3/", args: floats(1, 2, 3), want: parseAddress},
+		{name: "ID not a number", address: "/tracking/trackers/x/position", args: floats(1, 2, 3), want: parseID},
+		{name: "2 arguments", address: "/tracking/trackers/3/position", args: floats(1, 2), want: parseArgCount},
+		{name: "5 arguments", address: "/tracking/trackers/3/rotation", args: floats(1, 2, 3, 4, 5), want: parseArgCount},
+		{name: "string argument", address: "/tracking/trackers/3/position", args: []any{float32(1), "2", float32(3)}, want: parseArgType},
+		{name: "unknown field", address: "/tracking/trackers/3/scale", args: floats(1, 2, 3), want: parseField},
+		{name: "position with 4 arguments", address: "/tracking/trackers/3/position", args: floats(1, 2, 3, 4), want: parseField},
+		{name: "pose with 3 arguments", address: "/tracking/trackers/3/pose", args: floats(1, 2, 3), want: parseField},
+		{name: "active with N", address: "/tracking/trackers/3/active", args: []any{nil}, want: parseArgType},
+		{name: "active with a number", address: "/tracking/trackers/3/active", args: floats(1), want: parseArgType},
+		{name: "active with 2 arguments", address: "/tracking/trackers/3/active", args: []any{true, false}, want: parseArgCount},
+
+		{name: "non-finite rejected", address: "/tracking/trackers/3/position", args: floats(1, inf, 3), want: parseNonFinite},
+		{name: "non-finite kept", address: "/tracking/trackers/3/position", args: floats(1, inf, 3), keep: true,
+			data: TrackerData{ID: 3, Position: [3]float32{1, inf, 3}, Fields: FieldPosition}},
+
+		{name: "id_arg", address: "/tracking/trackers/position", args: append([]any{int32(5)}, floats(1, 2, 3)...),
+			schema: func(s *Schema) { s.IDArg = 0 },
+			data:   TrackerData{ID: 5, Position: [3]float32{1, 2, 3}, Fields: FieldPosition}},
+		{name: "id_arg not an integer", address: "/tracking/trackers/position", args: floats(5, 1, 2, 3),
+			schema: func(s *Schema) { s.IDArg = 0 }, want: parseID},
+		{name: "id_arg missing", address: "/tracking/trackers/position", args: floats(1, 2, 3),
+			schema: func(s *Schema) { s.IDArg = 3 }, want: parseArgCount},
+	})
+}
+
+// TestActiveImpulse checks the decoder drops an I status before it reaches
+// parseMessage, which relies on that.
+func TestActiveImpulse(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// "/tracking/trackers/3/active" padded to 28 bytes, then ",I" padded to 4
+	raw := append([]byte("/tracking/trackers/3/active\x00"), ',', 'I', 0, 0)
+	if _, err := conn.WriteTo(raw, conn.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	reader := &osc.Server{}
+	if packet, addr, err := reader.Read(conn); err == nil || addr == nil {
+		t.Fatalf("decoded %v from %v, err %v, want the packet rejected", packet, addr, err)
+	}
+}
+
+func TestNormalizeAddress(t *testing.T) {
+	for _, c := range []struct{ in, want string }{
+		{"/tracking/trackers/3/position", "/tracking/trackers/3/position"},
+		{"//tracking/trackers/3//position/", "/tracking/trackers/3/position"},
+		{"/tracking///trackers/3/position", "/tracking/trackers/3/position"},
+		{"/tracking/trackers/3/position//", "/tracking/trackers/3/position"},
+		{"/", "/"},
+		{"//", "/"},
+	} {
+		if got := normalizeAddress(c.in); got != c.want {
+			t.Errorf("normalizeAddress(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestParseAddressVariants(t *testing.T) {
+	position := TrackerData{ID: 3, Position: [3]float32{1, 2, 3}, Fields: FieldPosition}
+	runParseCases(t, []parseCase{
+		{name: "trailing slash", address: "/tracking/trackers/3/position/", args: floats(1, 2, 3), data: position},
+		{name: "doubled slash before the field", address: "/tracking/trackers/3//position", args: floats(1, 2, 3), data: position},
+		{name: "doubled slash before the ID", address: "/tracking/trackers//3/position", args: floats(1, 2, 3), data: position},
+		{name: "extra segment after the field", address: "/tracking/trackers/3/position/raw", args: floats(1, 2, 3), data: position},
+		{name: "extra segment before the field", address: "/tracking/trackers/3/left/position", args: floats(1, 2, 3), data: position},
+		{name: "only slashes after the ID", address: "/tracking/trackers/3//", args: floats(1, 2, 3), want: parseAddress},
+		{name: "namespace only", address: "/tracking/trackers/", args: floats(1, 2, 3), want: parseNamespace},
+	})
+}
+
+func TestReorder(t *testing.T) {
+	args := []float32{1, 2, 3}
+	for _, c := range []struct {
+		order []int
+		want  [3]float32
+	}{
+		{nil, [3]float32{1, 2, 3}},
+		{[]int{0, 1, 2}, [3]float32{1, 2, 3}},
+		// the source sends z,x,y
+		{[]int{1, 2, 0}, [3]float32{2, 3, 1}},
+		{[]int{2, 0, 1}, [3]float32{3, 1, 2}},
+		{[]int{2, 1, 0}, [3]float32{3, 2, 1}},
+	} {
+		if got := reorder(args, c.order); got != c.want {
+			t.Errorf("reorder(%v, %v) = %v, want %v", args, c.order, got, c.want)
+		}
+	}
+}
+
+func TestValidateOrder(t *testing.T) {
+	for _, order := range [][]int{{0, 0, 1}, {0, 1}, {0, 1, 3}, {-1, 0, 1}, {0, 1, 2, 0}} {
+		if validateOrder(order) == nil {
+			t.Errorf("validateOrder(%v) accepted an order that is not a permutation", order)
+		}
+	}
+	for _, order := range [][]int{nil, {2, 0, 1}} {
+		if err := validateOrder(order); err != nil {
+			t.Errorf("validateOrder(%v) = %v", order, err)
+		}
+	}
+}
+
+func TestParseAxisOrder(t *testing.T) {
+	runParseCases(t, []parseCase{
+		{name: "position_order", address: "/tracking/trackers/3/position", args: floats(1, 2, 3),
+			schema: func(s *Schema) { s.PositionOrder = []int{2, 0, 1} },
+			data:   TrackerData{ID: 3, Position: [3]float32{3, 1, 2}, Fields: FieldPosition}},
+		{name: "rotation_order", address: "/tracking/trackers/3/rotation", args: floats(10, 20, 30),
+			schema: func(s *Schema) { s.RotationOrder = []int{1, 2, 0} },
+			data:   TrackerData{ID: 3, Rotation: [3]float32{20, 30, 10}, Fields: FieldRotation}},
+		{name: "orders in a pose", address: "/tracking/trackers/3/pose", args: floats(1, 2, 3, 10, 20, 30),
+			schema: func(s *Schema) { s.PositionOrder, s.RotationOrder = []int{2, 1, 0}, []int{1, 0, 2} },
+			data:   TrackerData{ID: 3, Position: [3]float32{3, 2, 1}, Rotation: [3]float32{20, 10, 30}, Fields: FieldPosition | FieldRotation}},
+	})
+}
+
+func TestParseIDArgument(t *testing.T) {
+	idArg := func(i int) func(s *Schema) {
+		return func(s *Schema) { s.IDArg = i }
+	}
+	runParseCases(t, []parseCase{
+		{name: "ID last", address: "/tracking/trackers/position", args: append(floats(1, 2, 3), int32(5)), schema: idArg(3),
+			data: TrackerData{ID: 5, Position: [3]float32{1, 2, 3}, Fields: FieldPosition}},
+		{name: "ID between the axes", address: "/tracking/trackers/position", args: []any{float32(1), int64(5), float32(2), float32(3)}, schema: idArg(1),
+			data: TrackerData{ID: 5, Position: [3]float32{1, 2, 3}, Fields: FieldPosition}},
+		{name: "quaternion", address: "/tracking/trackers/rotation", args: append([]any{int32(5)}, floats(0, 0, 0, 1)...), schema: idArg(0),
+			data: TrackerData{ID: 5, Quaternion: [4]float32{0, 0, 0, 1}, Fields: FieldQuaternion}},
+		{name: "pose", address: "/tracking/trackers/pose", args: append([]any{int32(5)}, floats(1, 2, 3, 10, 20, 30)...), schema: idArg(0),
+			data: TrackerData{ID: 5, Position: [3]float32{1, 2, 3}, Rotation: [3]float32{10, 20, 30}, Fields: FieldPosition | FieldRotation}},
+		{name: "active", address: "/tracking/trackers/active", args: []any{int32(5), false}, schema: idArg(0),
+			data: TrackerData{ID: 5, Inactive: true, Fields: FieldActive}},
+		{name: "handler", address: "/tracking/update", args: append([]any{int32(5)}, floats(1, 2, 3)...),
+			schema: func(s *Schema) {
+				s.IDArg = 0
+				s.Handlers = []Handler{{Pattern: "/tracking/update", Kind: KindPosition}}
+			},
+			data: TrackerData{ID: 5, Position: [3]float32{1, 2, 3}, Fields: FieldPosition}},
+		{name: "address ID ignored", address: "/tracking/trackers/9/position", args: append([]any{int32(5)}, floats(1, 2, 3)...), schema: idArg(0),
+			data: TrackerData{ID: 5, Position: [3]float32{1, 2, 3}, Fields: FieldPosition}},
+
+		{name: "too many arguments", address: "/tracking/trackers/pose", args: append([]any{int32(5)}, floats(1, 2, 3, 4, 5, 6, 7)...), schema: idArg(0), want: parseArgCount},
+		{name: "only the ID", address: "/tracking/trackers/position", args: []any{int32(5)}, schema: idArg(0), want: parseArgCount},
+		{name: "ID a string", address: "/tracking/trackers/position", args: append([]any{"5"}, floats(1, 2, 3)...), schema: idArg(0), want: parseID},
+	})
+
+	for _, c := range []struct {
+		idArg int
+		ok    bool
+	}{{-1, true}, {0, true}, {maxValueArgs, true}, {-2, false}, {maxValueArgs + 1, false}} {
+		schema := DefaultConfig().Schema
+		schema.IDArg = c.idArg
+		if err := schema.Validate(); (err == nil) != c.ok {
+			t.Errorf("id_arg %d: error %v, want ok=%v", c.idArg, err, c.ok)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+// matchPattern reports whether address matches the OSC address pattern,
+// following the OSC 1.0 rules: '?' matches any single character, '*' any
+// sequence of characters, "[abc]", "[a-z]" and "[!abc]" one character from
+// (or not from) the set, and "{foo,bar}" any one of the strings. Patterns
+// are matched part by part, no wildcard matches a '/'.
+func matchPattern(pattern, address string) bool {
+	patternParts := strings.Split(pattern, "/")
+	addressParts := strings.Split(address, "/")
+	if len(patternParts) != len(addressParts) {
+		return false
+	}
+	for i := range patternParts {
+		if !matchPart(patternParts[i], addressParts[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchPart matches one '/' separated part of an address.
+func matchPart(p, s string) bool {
+	for len(p) > 0 {
+		switch p[0] {
+		case '*':
+			p = strings.TrimLeft(p, "*")
+			if p == "" {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if matchPart(p, s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if s == "" {
+				return false
+			}
+		case '[':
+			end := strings.IndexByte(p, ']')
+			if end < 0 || s == "" || !matchSet(p[1:end], s[0]) {
+				return false
+			}
+			p, s = p[end+1:], s[1:]
+			continue
+		case '{':
+			end := strings.IndexByte(p, '}')
+			if end < 0 {
+				return false
+			}
+			for _, alt := range strings.Split(p[1:end], ",") {
+				if strings.HasPrefix(s, alt) && matchPart(p[end+1:], s[len(alt):]) {
+					return true
+				}
+			}
+			return false
+		default:
+			if s == "" || s[0] != p[0] {
+				return false
+			}
+		}
+		p, s = p[1:], s[1:]
+	}
+	return s == ""
+}
+
+// matchSet reports whether c is in the bracket expression set (without the
+// brackets). A leading '!' negates it, "a-z" is a range and a '-' at the end
+// stands for itself.
+func matchSet(set string, c byte) bool {
+	negate := strings.HasPrefix(set, "!")
+	if negate {
+		set = set[1:]
+	}
+	found := false
+	for i := 0; i < len(set); i++ {
+		if i+2 < len(set) && set[i+1] == '-' {
+			if set[i] <= c && c <= set[i+2] {
+				found = true
+			}
+			i += 2
+		} else if set[i] == c {
+			found = true
+		}
+	}
+	return found != negate
+}
+
+// validatePattern checks that pattern is an absolute address with balanced
+// brackets and braces, which do not nest or span a '/'.
+func validatePattern(pattern string) error {
+	if !strings.HasPrefix(pattern, "/") {
+		return errors.New("must start with /")
+	}
+	var open byte
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case open == 0 && (c == '[' || c == '{'):
+			open = c
+		case open == 0 && (c == ']' || c == '}'):
+			return errors.New("unopened " + string(c))
+		case open != 0 && (c == '[' || c == '{' || c == '/'):
+			return errors.New("unclosed " + string(open))
+		case open == '[' && c == ']', open == '{' && c == '}':
+			open = 0
+		}
+	}
+	if open != 0 {
+		return errors.New("unclosed " + string(open))
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import "testing"
+
+func TestMatchPattern(t *testing.T) {
+	for _, c := range []struct {
+		pattern, address string
+		want             bool
+	}{
+		{"/tracking/trackers/3/position", "/tracking/trackers/3/position", true},
+		{"/tracking/trackers/3/position", "/tracking/trackers/4/position", false},
+		// '?' is exactly one character
+		{"/t/?/position", "/t/3/position", true},
+		{"/t/?/position", "/t/12/position", false},
+		{"/t/?/position", "/t//position", false},
+		// '*' is any sequence, including none, within one part
+		{"/t/*/position", "/t/12/position", true},
+		{"/t/*/position", "/t//position", true},
+		{"/t/*", "/t/3/position", false},
+		{"/t/1*3/position", "/t/1223/position", true},
+		{"/t/1*3/position", "/t/1224/position", false},
+		{"/t/**/position", "/t/5/position", true},
+		// character sets, ranges and negation
+		{"/t/[0-9]/position", "/t/7/position", true},
+		{"/t/[0-9]/position", "/t/x/position", false},
+		{"/t/[abc]/position", "/t/b/position", true},
+		{"/t/[!abc]/position", "/t/b/position", false},
+		{"/t/[!abc]/position", "/t/d/position", true},
+		{"/t/[a-cx]/position", "/t/x/position", true},
+		{"/t/[ab-]/position", "/t/-/position", true},
+		{"/t/[0-9]*/position", "/t/42/position", true},
+		{"/t/[0-9]*/position", "/t/x42/position", false},
+		// alternatives
+		{"/t/3/{position,rotation}", "/t/3/rotation", true},
+		{"/t/3/{position,rotation}", "/t/3/pose", false},
+		{"/t/3/{pos,position}", "/t/3/position", true},
+		{"/t/3/{left,right}hand", "/t/3/righthand", true},
+		// malformed patterns match nothing
+		{"/t/[0-9/position", "/t/1/position", false},
+		{"/t/{a,b/position", "/t/a/position", false},
+	} {
+		if got := matchPattern(c.pattern, c.address); got != c.want {
+			t.Errorf("matchPattern(%q, %q) = %v, want %v", c.pattern, c.address, got, c.want)
+		}
+	}
+}
+
+func TestValidatePattern(t *testing.T) {
+	for _, pattern := range []string{"/t/[0-9]*/position", "/t/{a,b}/[!x]", "/"} {
+		if err := validatePattern(pattern); err != nil {
+			t.Errorf("validatePattern(%q) = %v", pattern, err)
+		}
+	}
+	for _, pattern := range []string{"t/3/position", "/t/[0-9/x", "/t/{a,b", "/t/a]", "/t/{a/b}", "/t/[a{b}]"} {
+		if validatePattern(pattern) == nil {
+			t.Errorf("validatePattern(%q) accepted a malformed pattern", pattern)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"github.com/crgimenes/go-osc"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// answerQuery replies to a tracker list query with a bundle holding one
+// <address>/tracker message per active tracker. Each carries the ID, the
+// seconds since it was last seen and, when namespaced, its source.
+func answerQuery(tm *TrackerManager, address string, from net.Addr) {
+	p, ok := from.(*peer)
+	if !ok {
+		return // replayed, nobody to answer
+	}
+
+	now := time.Now()
+	bundle := osc.NewBundle(now)
+	for _, tracker := range tm.GetAllTrackers() {
+		msg := osc.NewMessage(address + "/tracker")
+		msg.Append(int32(tracker.ID))
+		msg.Append(float32(now.Sub(tracker.LastSeen).Seconds()))
+		if tracker.Source != "" {
+			msg.Append(tracker.Source)
+		}
+		bundle.Append(msg)
+	}
+	if err := p.reply(bundle); err != nil {
+		slog.Warn("Answering tracker query failed", "to", from.String(), "err", err)
+	}
+}
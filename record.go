@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"github.com/crgimenes/go-osc"
+	"log/slog"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// recordEntry is one line of a capture file.
+type recordEntry struct {
+	Time    int64  `json:"t"`     // receive time, unix nanoseconds
+	Address string `json:"addr"`  // OSC address
+	Types   string `json:"types"` // OSC type tags without the leading ','
+	Args    []any  `json:"args"`  // argument values, blobs are base64, NaN and infinities strings
+}
+
+// Recorder writes every received OSC message to a file as one JSON object per
+// line. A path ending in .gz is gzip compressed. Writes are buffered, Close
+// flushes them.
+type Recorder struct {
+	mu   sync.Mutex
+	file *os.File
+	gz   *gzip.Writer // nil when not compressing
+	w    *bufio.Writer
+	enc  *json.Encoder
+}
+
+func NewRecorder(path string) (*Recorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &Recorder{file: file}
+	if isGzipPath(path) {
+		r.gz = gzip.NewWriter(file)
+		r.w = bufio.NewWriterSize(r.gz, 64*1024)
+	} else {
+		r.w = bufio.NewWriterSize(file, 64*1024)
+	}
+	r.enc = json.NewEncoder(r.w)
+	return r, nil
+}
+
+func (r *Recorder) Record(msg *osc.Message, at time.Time) {
+	entry := recordEntry{
+		Time:    at.UnixNano(),
+		Address: msg.Address,
+		Types:   typeTags(msg.Arguments),
+		Args:    recordArgs(msg.Arguments),
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.enc == nil {
+		return // closed
+	}
+	if err := r.enc.Encode(entry); err != nil {
+		slog.Warn("Error recording message", "addr", msg.Address, "err", err)
+	}
+}
+
+// Close flushes buffered entries, writes the gzip trailer and closes the
+// file. Without it a compressed capture is truncated.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.enc == nil {
+		return nil
+	}
+	r.enc = nil
+	err := r.w.Flush()
+	if r.gz != nil {
+		if gzErr := r.gz.Close(); err == nil {
+			err = gzErr
+		}
+	}
+	if err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
+
+// recordArgs returns args with the floats JSON cannot hold, NaN and the
+// infinities, replaced by their strconv spelling. The type tag tells a reader
+// to parse them back. args is copied only when there is something to replace.
+func recordArgs(args []any) []any {
+	out, copied := args, false
+	for i, arg := range args {
+		var f float64
+		switch v := arg.(type) {
+		case float32:
+			f = float64(v)
+		case float64:
+			f = v
+		default:
+			continue
+		}
+		if !math.IsNaN(f) && !math.IsInf(f, 0) {
+			continue
+		}
+		if !copied {
+			out, copied = append([]any(nil), args...), true
+		}
+		out[i] = strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return out
+}
+
+func isGzipPath(path string) bool {
+	return strings.HasSuffix(strings.ToLower(path), ".gz")
+}
+
+// typeTags returns the OSC type tags of args, the same letters go-osc uses on
+// the wire.
+func typeTags(args []any) string {
+	tags := make([]byte, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case bool:
+			if v {
+				tags[i] = 'T'
+			} else {
+				tags[i] = 'F'
+			}
+		case nil:
+			tags[i] = 'N'
+		case int32:
+			tags[i] = 'i'
+		case float32:
+			tags[i] = 'f'
+		case string:
+			tags[i] = 's'
+		case []byte:
+			tags[i] = 'b'
+		case int64:
+			tags[i] = 'h'
+		case float64:
+			tags[i] = 'd'
+		case osc.Timetag:
+			tags[i] = 't'
+		default:
+			tags[i] = '?'
+		}
+	}
+	return string(tags)
+}
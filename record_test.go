@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/crgimenes/go-osc"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// roundTrip records msgs to a file named name and replays them.
+func roundTrip(t *testing.T, name string, msgs ...*osc.Message) []*osc.Message {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	r, err := NewRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range msgs {
+		r.Record(msg, time.Now())
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var out []*osc.Message
+	handle := func(msg *osc.Message, _ time.Time, _ net.Addr) { out = append(out, msg) }
+	if err := replayFile(path, 0, false, handle, nil); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestRecordNonFiniteFloats(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+	r, err := NewRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := osc.NewMessage("/tracking/trackers/1/position",
+		float32(math.NaN()), float32(math.Inf(1)), float32(math.Inf(-1)), math.NaN(), math.Inf(1), math.Inf(-1), float32(1.5))
+	r.Record(in, time.Now())
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entry recordEntry
+	if err := json.Unmarshal(raw, &entry); err != nil {
+		t.Fatalf("recorded %q: %v", raw, err)
+	}
+	if want := "fffdddf"; entry.Types != want {
+		t.Errorf("recorded types %s, want %s", entry.Types, want)
+	}
+	if want := []any{"NaN", "+Inf", "-Inf", "NaN", "+Inf", "-Inf", 1.5}; !reflect.DeepEqual(entry.Args, want) {
+		t.Errorf("recorded args %#v, want %#v", entry.Args, want)
+	}
+	if !math.IsNaN(float64(in.Arguments[0].(float32))) {
+		t.Error("recording changed the message")
+	}
+}
+
+func TestReplayNonFiniteFloats(t *testing.T) {
+	in := osc.NewMessage("/tracking/trackers/1/position",
+		float32(math.NaN()), float32(math.Inf(1)), float32(math.Inf(-1)), math.NaN(), math.Inf(1), math.Inf(-1), float32(1.5))
+
+	out := roundTrip(t, "capture.jsonl", in)
+	if len(out) != 1 {
+		t.Fatalf("replayed %d messages, want 1", len(out))
+	}
+	// compared printed, NaN is not equal to itself
+	if got, want := fmt.Sprintf("%#v", out[0].Arguments), fmt.Sprintf("%#v", in.Arguments); got != want {
+		t.Errorf("replayed %s, want %s", got, want)
+	}
+}
+
+func TestDecodeArgRejectsFiniteStrings(t *testing.T) {
+	for _, arg := range []any{"1.5", "nope"} {
+		if _, err := decodeArg('f', arg); err == nil {
+			t.Errorf("decodeArg('f', %q) accepted a string that is not NaN or an infinity", arg)
+		}
+	}
+}
+
+func TestGzipRoundTrip(t *testing.T) {
+	in := []*osc.Message{
+		osc.NewMessage("/tracking/trackers/1/position", float32(1), float32(2), float32(3)),
+		osc.NewMessage("/tracking/trackers/1/active", true),
+		osc.NewMessage("/misc", int32(-4), int64(1<<40), 2.5, "text", []byte{0, 1, 2}, nil, osc.Timetag(12345)),
+	}
+	dir := t.TempDir()
+	out := roundTrip(t, "capture.jsonl.gz", in...)
+	if len(out) != len(in) {
+		t.Fatalf("replayed %d messages, want %d", len(out), len(in))
+	}
+	for i := range in {
+		if out[i].Address != in[i].Address || !reflect.DeepEqual(out[i].Arguments, in[i].Arguments) {
+			t.Errorf("replayed %s %#v, want %s %#v", out[i].Address, out[i].Arguments, in[i].Address, in[i].Arguments)
+		}
+	}
+
+	// the same again to look at the file itself
+	path := filepath.Join(dir, "capture.JSONL.GZ")
+	r, err := NewRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Record(in[0], time.Now())
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(raw) < 2 || raw[0] != 0x1f || raw[1] != 0x8b {
+		t.Errorf("%s is not gzip compressed", path)
+	}
+}
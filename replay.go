@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/crgimenes/go-osc"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"strconv"
+	"time"
+)
+
+// replayFile reads a capture written by Recorder and hands each message to
+// handle, keeping the recorded gaps between messages divided by speed. A
+// speed <= 0 replays as fast as possible. With loop the file starts over at
+// the end. A path ending in .gz is decompressed. It returns when the file is
+// done or stop is closed.
+func replayFile(path string, speed float64, loop bool, handle messageHandler, stop <-chan struct{}) error {
+	for {
+		done, err := replayOnce(path, speed, handle, stop)
+		if err != nil || done || !loop {
+			return err
+		}
+	}
+}
+
+// replayOnce plays the file once, done is true when stop was closed.
+func replayOnce(path string, speed float64, handle messageHandler, stop <-chan struct{}) (done bool, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if isGzipPath(path) {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return false, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var prev int64
+	line := 0
+	for scanner.Scan() {
+		line++
+		var entry recordEntry
+		dec := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+		dec.UseNumber()
+		if err := dec.Decode(&entry); err != nil {
+			slog.Warn("Skipping malformed replay line", "path", path, "line", line, "err", err)
+			continue
+		}
+		msg, err := entry.message()
+		if err != nil {
+			slog.Warn("Skipping malformed replay line", "path", path, "line", line, "err", err)
+			continue
+		}
+
+		if speed > 0 && prev != 0 && entry.Time > prev {
+			wait := time.Duration(float64(entry.Time-prev) / speed)
+			select {
+			case <-stop:
+				return true, nil
+			case <-time.After(wait):
+			}
+		} else {
+			select {
+			case <-stop:
+				return true, nil
+			default:
+			}
+		}
+		prev = entry.Time
+
+		handle(msg, time.Time{}, nil)
+	}
+	if err := scanner.Err(); err != nil {
+		slog.Warn("Replay stopped early", "path", path, "line", line, "err", err)
+	}
+	return false, nil
+}
+
+// message rebuilds the OSC message, using the type tags to restore the
+// argument types JSON lost.
+func (e recordEntry) message() (*osc.Message, error) {
+	if len(e.Types) != len(e.Args) {
+		return nil, fmt.Errorf("%d type tags for %d arguments", len(e.Types), len(e.Args))
+	}
+
+	msg := osc.NewMessage(e.Address)
+	for i, arg := range e.Args {
+		v, err := decodeArg(e.Types[i], arg)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %w", i, err)
+		}
+		msg.Append(v)
+	}
+	return msg, nil
+}
+
+func decodeArg(tag byte, arg any) (any, error) {
+	switch tag {
+	case 'T', 'F':
+		if b, ok := arg.(bool); ok {
+			return b, nil
+		}
+	case 'N':
+		return nil, nil
+	case 's':
+		if s, ok := arg.(string); ok {
+			return s, nil
+		}
+	case 'b':
+		if s, ok := arg.(string); ok {
+			return base64.StdEncoding.DecodeString(s)
+		}
+	case 'f', 'd':
+		if s, ok := arg.(string); ok { // NaN or an infinity, see recordArgs
+			v, err := strconv.ParseFloat(s, 64)
+			if err != nil || (!math.IsNaN(v) && !math.IsInf(v, 0)) {
+				break
+			}
+			if tag == 'f' {
+				return float32(v), nil
+			}
+			return v, nil
+		}
+		fallthrough
+	case 'i', 'h', 't':
+		num, ok := arg.(json.Number)
+		if !ok {
+			break
+		}
+		switch tag {
+		case 'i':
+			v, err := strconv.ParseInt(num.String(), 10, 32)
+			return int32(v), err
+		case 'h':
+			return num.Int64()
+		case 'f':
+			v, err := strconv.ParseFloat(num.String(), 32)
+			return float32(v), err
+		case 'd':
+			return num.Float64()
+		case 't':
+			v, err := strconv.ParseUint(num.String(), 10, 64)
+			return osc.Timetag(v), err
+		}
+	}
+	return nil, errors.New("value " + fmt.Sprint(arg) + " does not match type tag " + string(tag))
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// simulate feeds n synthetic trackers into tm at rate updates per second until
+// stop is closed. Each tracker follows its own Lissajous path around the
+// origin and faces along its direction of travel.
+func simulate(tm *TrackerManager, n int, rate float64, stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+	defer ticker.Stop()
+	start := time.Now()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			t := now.Sub(start).Seconds()
+			for id := 0; id < n; id++ {
+				tm.UpdateTracker(simulatedTracker(id, t))
+			}
+		}
+	}
+}
+
+// simulatedTracker is tracker id at t seconds into the simulation.
+func simulatedTracker(id int, t float64) TrackerData {
+	phase := float64(id) * 2 * math.Pi / 7
+	a, b := 0.5+0.1*float64(id%3), 0.7+0.1*float64(id%4)
+	x := math.Sin(a*t + phase)
+	y := 1 + 0.25*math.Sin(2*b*t+phase)
+	z := math.Sin(b * t)
+	dx, dz := a*math.Cos(a*t+phase), b*math.Cos(b*t)
+	yaw := math.Atan2(dx, dz) * 180 / math.Pi
+	return TrackerData{
+		ID:       id,
+		Position: [3]float32{float32(x), float32(y), float32(z)},
+		Rotation: [3]float32{0, float32(yaw), 0},
+		Fields:   FieldPosition | FieldRotation,
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// smoothState holds the last smoothed values of one tracker, so the filter
+// can be reset by deleting it when the tracker expires.
+type smoothState struct {
+	position    [3]float32
+	rotation    [3]float32
+	hasPosition bool
+	hasRotation bool
+
+	// only used by the spring, and as the smoothed rate of change by the One
+	// Euro filter
+	positionVelocity [3]float64
+	rotationVelocity [3]float64
+	positionTime     time.Time
+	rotationTime     time.Time
+}
+
+// smoothLinear is an exponential moving average, factor is the weight of the
+// previous value (0 = no smoothing, close to 1 = heavy smoothing).
+func smoothLinear(prev, in [3]float32, factor float64) [3]float32 {
+	out := [3]float32{}
+	for i := 0; i < 3; i++ {
+		out[i] = float32(float64(prev[i]) + (1-factor)*float64(in[i]-prev[i]))
+	}
+	return out
+}
+
+// smoothAngles is smoothLinear for Euler angles in degrees, it moves along the
+// shortest arc so 179 and -179 average to 180 instead of 0.
+func smoothAngles(prev, in [3]float32, factor float64) [3]float32 {
+	out := [3]float32{}
+	for i := 0; i < 3; i++ {
+		out[i] = float32(wrapAngle(float64(prev[i]) + (1-factor)*angleDelta(prev[i], in[i])))
+	}
+	return out
+}
+
+// smoothSpring moves prev towards in like a critically damped spring, which
+// follows without overshooting a step and without the jump an average makes
+// on each update. vel holds the spring's velocity per axis, dt is the seconds
+// since prev and halfLife about the seconds to cover half the way.
+func smoothSpring(prev, in [3]float32, vel *[3]float64, halfLife, dt float64) [3]float32 {
+	out := [3]float32{}
+	for i := 0; i < 3; i++ {
+		offset := springStep(float64(prev[i])-float64(in[i]), &vel[i], halfLife, dt)
+		out[i] = float32(float64(in[i]) + offset)
+	}
+	return out
+}
+
+// springAngles is smoothSpring for Euler angles in degrees, along the
+// shortest arc like smoothAngles.
+func springAngles(prev, in [3]float32, vel *[3]float64, halfLife, dt float64) [3]float32 {
+	out := [3]float32{}
+	for i := 0; i < 3; i++ {
+		offset := springStep(-angleDelta(prev[i], in[i]), &vel[i], halfLife, dt)
+		out[i] = float32(wrapAngle(float64(in[i]) + offset))
+	}
+	return out
+}
+
+// springStep advances a critically damped spring by dt seconds. offset is
+// the distance from the target, the new one is returned and vel updated.
+func springStep(offset float64, vel *float64, halfLife, dt float64) float64 {
+	if dt < 0 {
+		dt = 0 // out of order timestamps
+	}
+	y := 2 * math.Ln2 / halfLife
+	j := *vel + offset*y
+	decay := math.Exp(-y * dt)
+	*vel = decay * (*vel - j*y*dt)
+	return decay * (offset + j*dt)
+}
+
+// oneEuro is the One Euro filter (Casiez et al. 2012), a moving average whose
+// cutoff frequency rises with the speed of the input: at rest it smooths at
+// minCutoff Hz, which hides jitter, and every unit per second of movement adds
+// beta Hz, which cuts the lag of fast moves. dCutoff smooths the speed itself.
+type oneEuro struct {
+	minCutoff float64
+	beta      float64
+	dCutoff   float64
+}
+
+// smooth moves prev towards in by the share the filter allows for dt seconds.
+// rate holds the smoothed rate of change per axis.
+func (f oneEuro) smooth(prev, in [3]float32, rate *[3]float64, dt float64) [3]float32 {
+	out := [3]float32{}
+	for i := 0; i < 3; i++ {
+		out[i] = float32(float64(prev[i]) + f.step(float64(in[i])-float64(prev[i]), &rate[i], dt))
+	}
+	return out
+}
+
+// angles is smooth for Euler angles in degrees, along the shortest arc like
+// smoothAngles.
+func (f oneEuro) angles(prev, in [3]float32, rate *[3]float64, dt float64) [3]float32 {
+	out := [3]float32{}
+	for i := 0; i < 3; i++ {
+		out[i] = float32(wrapAngle(float64(prev[i]) + f.step(angleDelta(prev[i], in[i]), &rate[i], dt)))
+	}
+	return out
+}
+
+// step returns how far to move towards a value delta away after dt seconds
+// and updates rate. Without time passing, out of order or duplicate
+// timestamps, it does not move.
+func (f oneEuro) step(delta float64, rate *float64, dt float64) float64 {
+	if dt <= 0 {
+		return 0
+	}
+	*rate += lowPassAlpha(f.dCutoff, dt) * (delta/dt - *rate)
+	return lowPassAlpha(f.minCutoff+f.beta*math.Abs(*rate), dt) * delta
+}
+
+// lowPassAlpha is the weight of a new sample dt seconds after the previous
+// one in a first order low pass filter at cutoff Hz.
+func lowPassAlpha(cutoff, dt float64) float64 {
+	tau := 1 / (2 * math.Pi * cutoff)
+	return 1 / (1 + tau/dt)
+}
+
+// angleDelta returns the signed shortest difference from -> to in degrees.
+func angleDelta(from, to float32) float64 {
+	return wrapAngle(float64(to) - float64(from))
+}
+
+// normalizeAngles wraps each Euler angle into (-180,180], so sources sending
+// 0..360 and -180..180 look the same downstream.
+func normalizeAngles(angles [3]float32) [3]float32 {
+	for i := range angles {
+		angles[i] = float32(wrapAngle(float64(angles[i])))
+	}
+	return angles
+}
+
+// wrapAngle maps a into (-180,180].
+func wrapAngle(a float64) float64 {
+	a = math.Mod(a, 360)
+	if a > 180 {
+		a -= 360
+	} else if a <= -180 {
+		a += 360
+	}
+	return a
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// stepResponse feeds a step from 0 to 1 at 60 Hz for two seconds through
+// filter, which gets the previous output and the seconds since. It returns
+// the first output, the overshoot past 1 and how long it took to stay within
+// 2% of 1.
+func stepResponse(filter func(prev float32, dt float64) float32) (first, overshoot, settle float64) {
+	const dt = 1.0 / 60
+	out := float32(0)
+	for i := 1; i <= 120; i++ {
+		out = filter(out, dt)
+		if i == 1 {
+			first = float64(out)
+		}
+		overshoot = math.Max(overshoot, float64(out)-1)
+		if math.Abs(float64(out)-1) > 0.02 {
+			settle = float64(i) * dt
+		}
+	}
+	return first, overshoot, settle
+}
+
+func emaStep(factor float64) func(float32, float64) float32 {
+	return func(prev float32, _ float64) float32 {
+		return smoothLinear([3]float32{prev}, [3]float32{1}, factor)[0]
+	}
+}
+
+func springStepResponse(halfLife float64) func(float32, float64) float32 {
+	var vel [3]float64
+	return func(prev float32, dt float64) float32 {
+		return smoothSpring([3]float32{prev}, [3]float32{1}, &vel, halfLife, dt)[0]
+	}
+}
+
+func oneEuroStep(f oneEuro) func(float32, float64) float32 {
+	var rate [3]float64
+	return func(prev float32, dt float64) float32 {
+		return f.smooth([3]float32{prev}, [3]float32{1}, &rate, dt)[0]
+	}
+}
+
+func TestStepResponse(t *testing.T) {
+	// all with a half life of about 50ms at 60 Hz
+	emaFirst, emaOvershoot, emaSettle := stepResponse(emaStep(math.Pow(0.5, 1.0/3)))
+	springFirst, springOvershoot, springSettle := stepResponse(springStepResponse(0.05))
+	euroFirst, euroOvershoot, euroSettle := stepResponse(oneEuroStep(oneEuro{minCutoff: 2.2, dCutoff: 1}))
+	fastFirst, fastOvershoot, fastSettle := stepResponse(oneEuroStep(oneEuro{minCutoff: 2.2, beta: 5, dCutoff: 1}))
+	t.Logf("ema first %.3f settle %.3fs, spring first %.3f settle %.3fs, one euro first %.3f settle %.3fs, with beta first %.3f settle %.3fs",
+		emaFirst, emaSettle, springFirst, springSettle, euroFirst, euroSettle, fastFirst, fastSettle)
+
+	for name, overshoot := range map[string]float64{"ema": emaOvershoot, "spring": springOvershoot, "one euro": euroOvershoot, "one euro with beta": fastOvershoot} {
+		if overshoot > 1e-6 {
+			t.Errorf("%s overshoots a step by %v", name, overshoot)
+		}
+	}
+	if springSettle >= emaSettle {
+		t.Errorf("spring settles after %vs, not faster than the moving average's %vs", springSettle, emaSettle)
+	}
+	if springFirst >= emaFirst {
+		t.Errorf("spring's first update %v is not slower than the moving average's %v", springFirst, emaFirst)
+	}
+	if fastSettle >= euroSettle || fastFirst <= euroFirst {
+		t.Errorf("beta does not speed up the One Euro filter: first %v vs %v, settled after %vs vs %vs", fastFirst, euroFirst, fastSettle, euroSettle)
+	}
+}
+
+func TestOneEuroIgnoresTimeStandingStill(t *testing.T) {
+	var rate [3]float64
+	f := oneEuro{minCutoff: 1, beta: 1, dCutoff: 1}
+	if out := f.smooth([3]float32{1, 2, 3}, [3]float32{5, 5, 5}, &rate, 0); out != [3]float32{1, 2, 3} {
+		t.Errorf("moved to %v without time passing", out)
+	}
+	if rate != [3]float64{} {
+		t.Errorf("rate %v after no time passed", rate)
+	}
+}
+
+func TestOneEuroAnglesWrap(t *testing.T) {
+	var rate [3]float64
+	f := oneEuro{minCutoff: 1, dCutoff: 1}
+	out := f.angles([3]float32{179}, [3]float32{-179}, &rate, 1.0/60)
+	if out[0] < 179 && out[0] > -179 {
+		t.Errorf("179 towards -179 went the long way to %v", out[0])
+	}
+}
+
+func TestNormalizeAngles(t *testing.T) {
+	for _, c := range []struct{ in, want [3]float32 }{
+		{[3]float32{0, 90, -90}, [3]float32{0, 90, -90}},
+		{[3]float32{270, 359, 360}, [3]float32{-90, -1, 0}},
+		{[3]float32{180, -180, 540}, [3]float32{180, 180, 180}},
+		{[3]float32{-190, 720, -359}, [3]float32{170, 0, 1}},
+	} {
+		if got := normalizeAngles(c.in); got != c.want {
+			t.Errorf("normalizeAngles(%v) = %v, want %v", c.in, got, c.want)
+		}
+	}
+}
+
+func TestAngleWraparound(t *testing.T) {
+	tm := newTestManager(t, DefaultConfig())
+	// a source sending 0..360 crossing 0, a 2 degree move
+	tm.process(rotation(1, 0, 359, 0))
+	tm.process(rotation(1, 0, 1, 0))
+	if tracker, _ := tm.GetTrackerData("", 1); tracker.Rotation != [3]float32{0, 1, 0} {
+		t.Errorf("359 -> 1 stored as %v, want [0 1 0] and no inversion", tracker.Rotation)
+	}
+	// the same from a -180..180 source
+	tm.process(rotation(2, 179, 0, 0))
+	tm.process(rotation(2, -179, 0, 0))
+	if tracker, _ := tm.GetTrackerData("", 2); tracker.Rotation != [3]float32{-179, 0, 0} {
+		t.Errorf("179 -> -179 stored as %v, want [-179 0 0] and no inversion", tracker.Rotation)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// streamWriteTimeout bounds a single line write, a client that cannot take a
+// line in this time is disconnected
+const streamWriteTimeout = time.Second
+
+// startStream listens on addr and streams every processed update to each
+// client that connects as newline delimited JSON, the same objects /ws
+// sends. Closing the returned listener stops accepting, connected clients
+// are let go when the tracker manager shuts down.
+func startStream(addr string, tm *TrackerManager) (net.Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		slog.Info("Starting update stream", "addr", ln.Addr().String())
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go streamUpdates(conn, tm)
+		}
+	}()
+	return ln, nil
+}
+
+// streamUpdates writes updates to conn until the client leaves or the
+// tracker manager shuts down. Each client reads from its own subscription, so
+// a slow one loses updates instead of holding up the pipeline.
+func streamUpdates(conn net.Conn, tm *TrackerManager) {
+	defer conn.Close()
+	updates, unsubscribe := tm.Subscribe()
+	defer unsubscribe()
+
+	// clients are not expected to send anything, reading only notices them
+	// closing the connection while no update comes in
+	closed := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, conn)
+		close(closed)
+	}()
+
+	slog.Debug("Stream client connected", "client", conn.RemoteAddr())
+	defer slog.Debug("Stream client disconnected", "client", conn.RemoteAddr())
+	enc := json.NewEncoder(conn)
+	for {
+		select {
+		case data, ok := <-updates:
+			if !ok {
+				return // shutting down
+			}
+			conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			if enc.Encode(wsUpdate{TrackerData: data, Fields: updateFieldNames(data.Fields)}) != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
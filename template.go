@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// defaultAddress is the output address used when a destination sets none
+const defaultAddress = "/tracking/trackers/{id}/{field}"
+
+// addressTemplate is a parsed output address, literal text with {id},
+// {field} and {source} placeholders.
+type addressTemplate struct {
+	segments []string // literal text and placeholders, in order
+	source   bool     // the template places the source itself
+}
+
+var defaultAddressTemplate, _ = parseAddressTemplate(defaultAddress)
+
+// parseAddressTemplate splits template into its segments. It has to start
+// with a '/', and every '{' has to open a known placeholder.
+func parseAddressTemplate(template string) (addressTemplate, error) {
+	if !strings.HasPrefix(template, "/") {
+		return addressTemplate{}, errors.New("must start with /")
+	}
+	var t addressTemplate
+	for rest := template; rest != ""; {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			t.segments = append(t.segments, rest)
+			break
+		}
+		if start > 0 {
+			t.segments = append(t.segments, rest[:start])
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return addressTemplate{}, errors.New("unclosed {")
+		}
+		end += start
+		switch placeholder := rest[start : end+1]; placeholder {
+		case "{source}":
+			t.source = true
+			fallthrough
+		case "{id}", "{field}":
+			t.segments = append(t.segments, placeholder)
+		default:
+			return addressTemplate{}, errors.New("unknown placeholder " + placeholder + ", must be {id}, {field} or {source}")
+		}
+		rest = rest[end+1:]
+	}
+	for _, segment := range t.segments {
+		if !strings.HasPrefix(segment, "{") && strings.ContainsAny(segment, " #*,?[]{}") {
+			return addressTemplate{}, errors.New("contains characters not allowed in an OSC address")
+		}
+	}
+	return t, nil
+}
+
+// has reports whether the template contains placeholder.
+func (t addressTemplate) has(placeholder string) bool {
+	for _, segment := range t.segments {
+		if segment == placeholder {
+			return true
+		}
+	}
+	return false
+}
+
+// render builds the address of field of tracker id. Trackers from a
+// namespaced source go out under /{source} unless the template places the
+// source itself. Without a source, {source} and the '/' following it are
+// left out.
+func (t addressTemplate) render(source string, id int, field string) string {
+	var b strings.Builder
+	if source != "" && !t.source {
+		b.WriteString("/" + source)
+	}
+	skipSlash := false
+	for _, segment := range t.segments {
+		switch segment {
+		case "{id}":
+			b.WriteString(strconv.Itoa(id))
+		case "{field}":
+			b.WriteString(field)
+		case "{source}":
+			b.WriteString(source)
+			skipSlash = source == ""
+			continue
+		default:
+			if skipSlash {
+				segment = strings.TrimPrefix(segment, "/")
+			}
+			b.WriteString(segment)
+		}
+		skipSlash = false
+	}
+	return b.String()
+}
+
+// addressField is the name field goes out under, quaternions are sent as
+// rotation.
+func addressField(field uint8) string {
+	switch field {
+	case FieldPosition:
+		return "position"
+	case FieldVelocity:
+		return "velocity"
+	}
+	return "rotation"
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestRenderAddress(t *testing.T) {
+	for _, c := range []struct {
+		template, source string
+		want             string
+	}{
+		{defaultAddress, "", "/tracking/trackers/7/position"},
+		{"/vrc/tracker/{id}/{field}", "", "/vrc/tracker/7/position"},
+		{"/vrc/tracker/{id}/{field}", "left", "/left/vrc/tracker/7/position"},
+		{"/{source}/t{id}/{field}", "left", "/left/t7/position"},
+		{"/{source}/t{id}/{field}", "", "/t7/position"},
+	} {
+		tmpl, err := parseAddressTemplate(c.template)
+		if err != nil {
+			t.Fatalf("%s: %v", c.template, err)
+		}
+		if got := tmpl.render(c.source, 7, "position"); got != c.want {
+			t.Errorf("%s from source %q rendered %s, want %s", c.template, c.source, got, c.want)
+		}
+	}
+}
+
+func TestValidateAddressTemplate(t *testing.T) {
+	for _, c := range []struct {
+		address string
+		fields  []string
+		ok      bool
+	}{
+		{"/vrc/tracker/{id}/{field}", nil, true},
+		{"/vrc/tracker/{id}/pos", []string{"position"}, true},
+		{"/vrc/tracker/{id}", nil, false},
+		{"/vrc/tracker/{field}", nil, false},
+		{"vrc/tracker/{id}/{field}", nil, false},
+		{"/vrc/tracker/{id}/{name}", nil, false},
+		{"/vrc/tracker/{id/{field}", nil, false},
+		{"/vrc/tracker #{id}/{field}", nil, false},
+	} {
+		d := Destination{Host: "127.0.0.1", Port: 9000, Address: c.address, Fields: c.fields}
+		if err := d.Validate(); (err == nil) != c.ok {
+			t.Errorf("address %q with fields %v: error %v, want ok=%v", c.address, c.fields, err, c.ok)
+		}
+	}
+}
+
+func TestForwardAddressTemplate(t *testing.T) {
+	cfg := testConfig()
+	cfg.DestAddress = "/vrc/tracker/{id}/{field}"
+	f, senders := newTestForwarder(cfg)
+	data := position(4, 1, 2, 3)
+	data.Rotation, data.Fields = [3]float32{10, 20, 30}, data.Fields|FieldRotation
+
+	runForwarder(f, data)
+
+	want := []string{"/vrc/tracker/4/position", "/vrc/tracker/4/rotation"}
+	if got := addresses(senders[0].messages()); !slices.Equal(got, want) {
+		t.Errorf("sent to %v, want %v", got, want)
+	}
+}
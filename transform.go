@@ -0,0 +1,327 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// axisMap remaps a vector so that output axis i is input axis src[i] times sign[i].
+type axisMap struct {
+	src  [3]int
+	sign [3]float32
+}
+
+var identityAxes = axisMap{src: [3]int{0, 1, 2}, sign: [3]float32{1, 1, 1}}
+
+// parseAxisMap parses a remap like ["+x","+z","-y"]. Every axis has to be used
+// exactly once. An empty spec is the identity.
+func parseAxisMap(spec []string) (axisMap, error) {
+	if len(spec) == 0 {
+		return identityAxes, nil
+	}
+	if len(spec) != 3 {
+		return axisMap{}, fmt.Errorf("axis map %v needs exactly 3 entries", spec)
+	}
+
+	m := axisMap{}
+	used := [3]bool{}
+	for i, s := range spec {
+		s = strings.ToLower(strings.TrimSpace(s))
+		m.sign[i] = 1
+		if strings.HasPrefix(s, "-") {
+			m.sign[i] = -1
+			s = s[1:]
+		} else {
+			s = strings.TrimPrefix(s, "+")
+		}
+
+		axis := strings.Index("xyz", s)
+		if len(s) != 1 || axis < 0 {
+			return axisMap{}, fmt.Errorf("axis map %v: unknown axis %q", spec, spec[i])
+		}
+		if used[axis] {
+			return axisMap{}, fmt.Errorf("axis map %v: axis %s used more than once", spec, s)
+		}
+		used[axis] = true
+		m.src[i] = axis
+	}
+	return m, nil
+}
+
+func (m axisMap) apply(v [3]float32) [3]float32 {
+	return [3]float32{
+		v[m.src[0]] * m.sign[0],
+		v[m.src[1]] * m.sign[1],
+		v[m.src[2]] * m.sign[2],
+	}
+}
+
+// quaternion remaps the vector part of an x,y,z,w quaternion, w is kept.
+func (m axisMap) quaternion(q [4]float32) [4]float32 {
+	v := m.apply([3]float32{q[0], q[1], q[2]})
+	return [4]float32{v[0], v[1], v[2], q[3]}
+}
+
+// coordinateFrame is a coordinate convention, given by the direction each
+// axis points in: 0 right, 1 up, 2 forward, times sign.
+type coordinateFrame struct {
+	dir  [3]int
+	sign [3]float32
+}
+
+// framePresets are the conventions frame_preset and input_frame name
+var framePresets = map[string]coordinateFrame{
+	"unity":   {dir: [3]int{0, 1, 2}, sign: [3]float32{1, 1, 1}},  // Y up, Z forward, left-handed
+	"unreal":  {dir: [3]int{2, 0, 1}, sign: [3]float32{1, 1, 1}},  // Z up, X forward, left-handed
+	"opengl":  {dir: [3]int{0, 1, 2}, sign: [3]float32{1, 1, -1}}, // Y up, -Z forward, right-handed
+	"blender": {dir: [3]int{0, 2, 1}, sign: [3]float32{1, 1, 1}},  // Z up, Y forward, right-handed
+}
+
+// handedness is 1 for a left-handed frame and -1 for a right-handed one, the
+// determinant of its axes in right, up, forward terms.
+func (f coordinateFrame) handedness() float32 {
+	h := f.sign[0] * f.sign[1] * f.sign[2]
+	// odd permutations of the directions flip it once more
+	for i := 0; i < 3; i++ {
+		for j := i + 1; j < 3; j++ {
+			if f.dir[i] > f.dir[j] {
+				h = -h
+			}
+		}
+	}
+	return h
+}
+
+// convertFrame returns the remaps that carry positions and Euler rotations
+// from the preset named from to the one named to. Rotation angles follow the
+// axes like positions do, but turn the other way when the handedness
+// changes, the same remap applies to the vector part of quaternions.
+func convertFrame(from, to string) (position, rotation axisMap, err error) {
+	src, ok := framePresets[from]
+	if !ok {
+		return axisMap{}, axisMap{}, fmt.Errorf("unknown frame %q, must be one of %s", from, framePresetNames())
+	}
+	dst, ok := framePresets[to]
+	if !ok {
+		return axisMap{}, axisMap{}, fmt.Errorf("unknown frame %q, must be one of %s", to, framePresetNames())
+	}
+	flip := src.handedness() * dst.handedness()
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			if src.dir[j] == dst.dir[i] {
+				position.src[i] = j
+				position.sign[i] = src.sign[j] * dst.sign[i]
+			}
+		}
+		rotation.src[i] = position.src[i]
+		rotation.sign[i] = position.sign[i] * flip
+	}
+	return position, rotation, nil
+}
+
+func framePresetNames() string {
+	names := make([]string, 0, len(framePresets))
+	for name := range framePresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// mirror reflects the tracking space across the plane through the origin
+// normal to one axis, -1 disables it. Negating a position axis alone turns
+// every orientation into an improper rotation, a mirrored rotation keeps its
+// angle about the mirror axis and turns the other way about the other two.
+type mirror int
+
+const noMirror mirror = -1
+
+// parseMirror parses the axis name, empty disables mirroring.
+func parseMirror(s string) (mirror, error) {
+	if s == "" {
+		return noMirror, nil
+	}
+	axis := strings.Index("xyz", strings.ToLower(s))
+	if len(s) != 1 || axis < 0 {
+		return noMirror, fmt.Errorf("unknown axis %q, must be x, y or z", s)
+	}
+	return mirror(axis), nil
+}
+
+func (m mirror) position(v [3]float32) [3]float32 {
+	if m != noMirror {
+		v[m] = -v[m]
+	}
+	return v
+}
+
+// rotation mirrors Euler angles, each elemental rotation is reflected on its
+// own so this holds for any rotation order.
+func (m mirror) rotation(v [3]float32) [3]float32 {
+	if m == noMirror {
+		return v
+	}
+	for i := range v {
+		if i != int(m) {
+			v[i] = -v[i]
+		}
+	}
+	return v
+}
+
+// quaternion mirrors an x,y,z,w quaternion, the rotation axis is reflected
+// like a pseudovector and the angle kept.
+func (m mirror) quaternion(q [4]float32) [4]float32 {
+	if m == noMirror {
+		return q
+	}
+	for i := 0; i < 3; i++ {
+		if i != int(m) {
+			q[i] = -q[i]
+		}
+	}
+	return q
+}
+
+// scaleOffset computes in*scale + offset per axis.
+type scaleOffset struct {
+	scale  [3]float64
+	offset [3]float64
+}
+
+var identityScaleOffset = scaleOffset{scale: [3]float64{1, 1, 1}}
+
+// newScaleOffset builds the transform from config lists, which are either
+// empty (scale 1, offset 0) or have one entry per axis.
+func newScaleOffset(scale, offset []float64) (scaleOffset, error) {
+	t := identityScaleOffset
+	if len(scale) != 0 {
+		if len(scale) != 3 {
+			return scaleOffset{}, fmt.Errorf("scale %v needs exactly 3 entries", scale)
+		}
+		copy(t.scale[:], scale)
+	}
+	if len(offset) != 0 {
+		if len(offset) != 3 {
+			return scaleOffset{}, fmt.Errorf("offset %v needs exactly 3 entries", offset)
+		}
+		copy(t.offset[:], offset)
+	}
+	return t, nil
+}
+
+func (t scaleOffset) apply(v [3]float32) [3]float32 {
+	out := [3]float32{}
+	for i := 0; i < 3; i++ {
+		out[i] = float32(float64(v[i])*t.scale[i] + t.offset[i])
+	}
+	return out
+}
+
+// affine is a 3x3 matrix plus translation, out = m*in + t.
+type affine struct {
+	m [3][3]float64
+	t [3]float64
+}
+
+var identityAffine = affine{m: [3][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}}
+
+// parseMatrix builds the transform from a 3x3 matrix, or a 4x4 homogeneous
+// one whose last column is the translation and last row is 0,0,0,1. An empty
+// matrix is the identity.
+func parseMatrix(rows [][]float64) (affine, error) {
+	if len(rows) == 0 {
+		return identityAffine, nil
+	}
+	n := len(rows)
+	if n != 3 && n != 4 {
+		return affine{}, fmt.Errorf("matrix needs 3 or 4 rows, got %d", n)
+	}
+	for i, row := range rows {
+		if len(row) != n {
+			return affine{}, fmt.Errorf("matrix row %d has %d entries, want %d", i, len(row), n)
+		}
+	}
+	if n == 4 && (rows[3][0] != 0 || rows[3][1] != 0 || rows[3][2] != 0 || rows[3][3] != 1) {
+		return affine{}, fmt.Errorf("last row of a 4x4 matrix must be 0,0,0,1, got %v", rows[3])
+	}
+
+	a := affine{}
+	for i := 0; i < 3; i++ {
+		copy(a.m[i][:], rows[i][:3])
+		if n == 4 {
+			a.t[i] = rows[i][3]
+		}
+	}
+	return a, nil
+}
+
+// apply skips the zero entries of m, so a NaN component only spoils the
+// outputs it contributes to and non_finite: clamp can keep the others.
+func (a affine) apply(v [3]float32) [3]float32 {
+	out := [3]float32{}
+	for i := 0; i < 3; i++ {
+		sum := a.t[i]
+		for j := 0; j < 3; j++ {
+			if a.m[i][j] != 0 {
+				sum += a.m[i][j] * float64(v[j])
+			}
+		}
+		out[i] = float32(sum)
+	}
+	return out
+}
+
+// bounds is a per axis range positions have to stay in.
+type bounds struct {
+	min [3]float64
+	max [3]float64
+}
+
+var unbounded = bounds{
+	min: [3]float64{math.Inf(-1), math.Inf(-1), math.Inf(-1)},
+	max: [3]float64{math.Inf(1), math.Inf(1), math.Inf(1)},
+}
+
+// newBounds builds the range from config lists, which are either empty
+// (unbounded on that side) or have one entry per axis. A single axis can be
+// left open with -.inf or .inf.
+func newBounds(min, max []float64) (bounds, error) {
+	b := unbounded
+	if len(min) != 0 {
+		if len(min) != 3 {
+			return bounds{}, fmt.Errorf("min %v needs exactly 3 entries", min)
+		}
+		copy(b.min[:], min)
+	}
+	if len(max) != 0 {
+		if len(max) != 3 {
+			return bounds{}, fmt.Errorf("max %v needs exactly 3 entries", max)
+		}
+		copy(b.max[:], max)
+	}
+	for i := 0; i < 3; i++ {
+		if b.min[i] > b.max[i] {
+			return bounds{}, fmt.Errorf("min %g above max %g on axis %c", b.min[i], b.max[i], "xyz"[i])
+		}
+	}
+	return b, nil
+}
+
+func (b bounds) contains(v [3]float32) bool {
+	for i := 0; i < 3; i++ {
+		if float64(v[i]) < b.min[i] || float64(v[i]) > b.max[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (b bounds) clamp(v [3]float32) [3]float32 {
+	for i := 0; i < 3; i++ {
+		v[i] = float32(math.Max(b.min[i], math.Min(b.max[i], float64(v[i]))))
+	}
+	return v
+}
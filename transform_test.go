@@ -0,0 +1,111 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestAffineKeepsNaNToItsAxis(t *testing.T) {
+	nan := float32(math.NaN())
+	swap, err := parseMatrix([][]float64{{0, 1, 0}, {1, 0, 0}, {0, 0, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := swap.apply([3]float32{1, nan, 3})
+	if !math.IsNaN(float64(out[0])) || out[1] != 1 || out[2] != 6 {
+		t.Errorf("swapping X and Y of [1 NaN 3] gave %v, want [NaN 1 6]", out)
+	}
+	if out := identityAffine.apply([3]float32{nan, 2, 3}); out[1] != 2 || out[2] != 3 {
+		t.Errorf("identity spread a NaN in X to %v", out)
+	}
+}
+
+// rotate applies the rotation of unit quaternion q (x,y,z,w) to v.
+func rotate(q [4]float32, v [3]float32) [3]float32 {
+	r := quaternionMul(quaternionMul(q, [4]float32{v[0], v[1], v[2], 0}), quaternionInverse(q))
+	return [3]float32{r[0], r[1], r[2]}
+}
+
+func near(a, b [3]float32) bool {
+	for i := range a {
+		if math.Abs(float64(a[i]-b[i])) > 1e-5 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMirror(t *testing.T) {
+	m, err := parseMirror("x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.position([3]float32{1, 2, 3}); got != [3]float32{-1, 2, 3} {
+		t.Errorf("mirrored position %v, want [-1 2 3]", got)
+	}
+	if got := m.rotation([3]float32{10, 20, 30}); got != [3]float32{10, -20, -30} {
+		t.Errorf("mirrored rotation %v, want [10 -20 -30]", got)
+	}
+
+	// the mirrored rotation turns the mirrored vector into the mirror image
+	// of the rotated one
+	s := float32(math.Sqrt(0.5))
+	yaw90 := [4]float32{0, s, 0, s}
+	forward := [3]float32{1, 0, 0}
+	for axis := mirror(0); axis < 3; axis++ {
+		want := axis.position(rotate(yaw90, forward))
+		if got := rotate(axis.quaternion(yaw90), axis.position(forward)); !near(got, want) {
+			t.Errorf("mirror %c: rotated mirrored vector is %v, want the mirror image %v", "xyz"[axis], got, want)
+		}
+	}
+
+	if _, err := parseMirror("w"); err == nil {
+		t.Error("parseMirror accepted axis w")
+	}
+	if m, err := parseMirror(""); err != nil || m != noMirror {
+		t.Errorf("parseMirror(\"\") = %v, %v, want noMirror", m, err)
+	}
+}
+
+func TestFrameHandedness(t *testing.T) {
+	for name, want := range map[string]float32{"unity": 1, "unreal": 1, "opengl": -1, "blender": -1} {
+		if got := framePresets[name].handedness(); got != want {
+			t.Errorf("%s handedness %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestConvertFrame(t *testing.T) {
+	// 1 right, 2 up, 3 forward, turned 10 about right, 20 about up and 30
+	// about forward
+	pose := map[string][2][3]float32{
+		"unity":   {{1, 2, 3}, {10, 20, 30}},
+		"unreal":  {{3, 1, 2}, {30, 10, 20}},
+		"opengl":  {{1, 2, -3}, {-10, -20, 30}},
+		"blender": {{1, 3, 2}, {-10, -30, -20}},
+	}
+	for from, in := range pose {
+		for to, want := range pose {
+			position, rotation, err := convertFrame(from, to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := position.apply(in[0]); got != want[0] {
+				t.Errorf("%s to %s: position %v, want %v", from, to, got, want[0])
+			}
+			if got := rotation.apply(in[1]); got != want[1] {
+				t.Errorf("%s to %s: rotation %v, want %v", from, to, got, want[1])
+			}
+		}
+	}
+
+	if _, _, err := convertFrame("unity", "directx"); err == nil {
+		t.Error("converted to unknown frame directx")
+	}
+	cfg := DefaultConfig()
+	cfg.FramePreset, cfg.InputFrame = "unreal", "maya"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "maya") {
+		t.Errorf("config with input_frame maya: error %v, want one naming it", err)
+	}
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/crgimenes/go-osc"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Transports for the listener and destinations
+const (
+	TransportUDP  = "udp"
+	TransportTCP  = "tcp"  // OSC 1.1 style, packets are SLIP framed on the stream
+	TransportUnix = "unix" // datagrams on a Unix domain socket, the address is a file path
+)
+
+// IP families a wildcard listen address accepts
+const (
+	FamilyDual = "dual" // IPv4 and IPv6, on one socket where the OS allows it
+	FamilyIPv4 = "ipv4"
+	FamilyIPv6 = "ipv6"
+)
+
+// SLIP framing bytes (RFC 1055)
+const (
+	slipEnd    = 0xC0
+	slipEsc    = 0xDB
+	slipEscEnd = 0xDC
+	slipEscEsc = 0xDD
+)
+
+// slipEncode frames data with an END byte on both sides.
+func slipEncode(data []byte) []byte {
+	out := make([]byte, 0, len(data)+2)
+	out = append(out, slipEnd)
+	for _, b := range data {
+		switch b {
+		case slipEnd:
+			out = append(out, slipEsc, slipEscEnd)
+		case slipEsc:
+			out = append(out, slipEsc, slipEscEsc)
+		default:
+			out = append(out, b)
+		}
+	}
+	return append(out, slipEnd)
+}
+
+// slipConn adapts a stream of SLIP framed packets to net.PacketConn, so
+// serveOSC can read a TCP connection like a UDP socket.
+type slipConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func newSLIPConn(conn net.Conn) *slipConn {
+	return &slipConn{Conn: conn, r: bufio.NewReader(conn)}
+}
+
+// ReadFrom reads the next non-empty frame into p.
+func (c *slipConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n := 0
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch b {
+		case slipEnd:
+			if n > 0 {
+				return n, c.RemoteAddr(), nil
+			}
+			continue
+		case slipEsc:
+			if b, err = c.r.ReadByte(); err != nil {
+				return 0, nil, err
+			}
+			if b == slipEscEnd {
+				b = slipEnd
+			} else if b == slipEscEsc {
+				b = slipEsc
+			}
+		}
+		if n == len(p) {
+			return 0, nil, errors.New("SLIP frame too large")
+		}
+		p[n] = b
+		n++
+	}
+}
+
+func (c *slipConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if _, err := c.Write(slipEncode(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// streamListener accepts TCP connections and serves each one, Close also
+// closes the open connections.
+type streamListener struct {
+	ln    net.Listener
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+func listenStream(network, addr string) (*streamListener, error) {
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &streamListener{ln: ln, conns: make(map[net.Conn]struct{})}, nil
+}
+
+func (l *streamListener) serve(d osc.Dispatcher) error {
+	for {
+		conn, err := l.ln.Accept()
+		if err != nil {
+			return err
+		}
+		l.mu.Lock()
+		l.conns[conn] = struct{}{}
+		l.mu.Unlock()
+
+		go func() {
+			slog.Debug("OSC stream connected", "from", conn.RemoteAddr().String())
+			err := serveOSC(newSLIPConn(conn), d)
+			if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				slog.Warn("OSC stream failed", "from", conn.RemoteAddr().String(), "err", err)
+			}
+			l.mu.Lock()
+			delete(l.conns, conn)
+			l.mu.Unlock()
+			conn.Close()
+		}()
+	}
+}
+
+func (l *streamListener) Addr() net.Addr {
+	return l.ln.Addr()
+}
+
+func (l *streamListener) Close() error {
+	err := l.ln.Close()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for conn := range l.conns {
+		conn.Close()
+	}
+	return err
+}
+
+// socketOptions are applied to every connection a sender opens, unset
+// options keep the defaults.
+type socketOptions struct {
+	noDelay     *bool // TCP_NODELAY, TCP only
+	writeBuffer int   // SO_SNDBUF in bytes, 0 is the OS default
+}
+
+func (o socketOptions) apply(conn net.Conn) error {
+	if o.writeBuffer > 0 {
+		if c, ok := conn.(interface{ SetWriteBuffer(int) error }); ok {
+			if err := c.SetWriteBuffer(o.writeBuffer); err != nil {
+				return err
+			}
+		}
+	}
+	if o.noDelay != nil {
+		if c, ok := conn.(*net.TCPConn); ok {
+			return c.SetNoDelay(*o.noDelay)
+		}
+	}
+	return nil
+}
+
+// udpSender sends each packet as a datagram like osc.Client does, but builds
+// the address with net.JoinHostPort so IPv6 hosts work. It keeps one
+// connected socket, the kernel then reports an ICMP port unreachable for an
+// earlier datagram as a refused write, which is what lets the breaker notice
+// a UDP destination with nothing listening.
+type udpSender struct {
+	addr string
+	opts socketOptions
+	mu   sync.Mutex
+	conn *net.UDPConn
+}
+
+func newUDPSender(host string, port int, opts socketOptions) *udpSender {
+	return &udpSender{addr: net.JoinHostPort(host, fmt.Sprint(port)), opts: opts}
+}
+
+func (s *udpSender) Send(packet osc.Packet) error {
+	data, err := packet.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		raddr, err := net.ResolveUDPAddr("udp", s.addr)
+		if err != nil {
+			return err
+		}
+		conn, err := net.DialUDP("udp", nil, raddr)
+		if err != nil {
+			return err
+		}
+		if err := s.opts.apply(conn); err != nil {
+			conn.Close()
+			return err
+		}
+		s.conn = conn
+	}
+	_, err = s.conn.Write(data)
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		// the refusal is for an earlier datagram and fails the write
+		// without sending this one. Send it anyway, but still report the
+		// refusal, otherwise every other send to a dead port succeeds
+		// and the breaker never sees consecutive failures.
+		s.conn.Write(data)
+	}
+	return err
+}
+
+func (s *udpSender) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// validateSocketPath checks that a Unix socket can live at path: its
+// directory exists, and whatever is at path already is a socket.
+func validateSocketPath(path string) error {
+	if path == "" {
+		return errors.New("needs a socket path")
+	}
+	if dir, err := os.Stat(filepath.Dir(path)); err != nil {
+		return err
+	} else if !dir.IsDir() {
+		return fmt.Errorf("%s is not a directory", filepath.Dir(path))
+	}
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	return nil
+}
+
+// unixListener is a Unix datagram socket that removes its file on Close.
+type unixListener struct {
+	net.PacketConn
+	path string
+}
+
+// listenUnix binds a Unix datagram socket at path. A socket file left behind
+// by an earlier run is removed first, the bind would fail on it otherwise.
+func listenUnix(path string) (*unixListener, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	conn, err := net.ListenPacket("unixgram", path)
+	if err != nil {
+		return nil, err
+	}
+	return &unixListener{PacketConn: conn, path: path}, nil
+}
+
+func (l *unixListener) Close() error {
+	err := l.PacketConn.Close()
+	os.Remove(l.path)
+	return err
+}
+
+// unixSender sends each packet as a datagram to a Unix socket. The socket is
+// kept open and reopened after a failure, e.g. when the receiver restarted
+// and bound a new socket at the same path.
+type unixSender struct {
+	path string
+	opts socketOptions
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func newUnixSender(path string, opts socketOptions) *unixSender {
+	return &unixSender{path: path, opts: opts}
+}
+
+func (s *unixSender) Send(packet osc.Packet) error {
+	data, err := packet.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		conn, err := net.Dial("unixgram", s.path)
+		if err != nil {
+			return err
+		}
+		if err := s.opts.apply(conn); err != nil {
+			conn.Close()
+			return err
+		}
+		s.conn = conn
+	}
+	if _, err := s.conn.Write(data); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+// Close drops the socket, a later Send opens a new one.
+func (s *unixSender) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// tcpSender keeps a TCP connection to a destination open and sends SLIP
+// framed packets over it. After a failure the connection is dropped and
+// redialled on a later send, at most once per tcpRedialDelay.
+type tcpSender struct {
+	addr     string
+	opts     socketOptions
+	mu       sync.Mutex
+	conn     net.Conn
+	nextDial time.Time
+}
+
+const tcpRedialDelay = time.Second
+
+func newTCPSender(host string, port int, opts socketOptions) *tcpSender {
+	return &tcpSender{addr: net.JoinHostPort(host, fmt.Sprint(port)), opts: opts}
+}
+
+func (s *tcpSender) Send(packet osc.Packet) error {
+	data, err := packet.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		if time.Now().Before(s.nextDial) {
+			return errors.New("not connected")
+		}
+		conn, err := net.DialTimeout("tcp", s.addr, tcpRedialDelay)
+		if err == nil {
+			if err = s.opts.apply(conn); err != nil {
+				conn.Close()
+			}
+		}
+		if err != nil {
+			s.nextDial = time.Now().Add(tcpRedialDelay)
+			return err
+		}
+		slog.Info("Connected to destination", "destination", s.addr)
+		s.conn = conn
+	}
+
+	if _, err := s.conn.Write(slipEncode(data)); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		s.nextDial = time.Now().Add(tcpRedialDelay)
+		return err
+	}
+	return nil
+}
+
+// Close drops the connection, a later Send dials again.
+func (s *tcpSender) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
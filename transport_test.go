@@ -0,0 +1,87 @@
+package main
+
+import (
+	"github.com/crgimenes/go-osc"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestUDPSenderIPv6(t *testing.T) {
+	if s := newUDPSender("::1", 9000, socketOptions{}); s.addr != "[::1]:9000" {
+		t.Errorf("sender address %q, want [::1]:9000", s.addr)
+	}
+	cfg := DefaultConfig()
+	cfg.DestHost, cfg.DestPort = "fe80::1%eth0", 9000
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("IPv6 destination rejected: %v", err)
+	}
+	if dests := newDestinations(cfg.AllDestinations(), true, newRetryPolicy(cfg), newBreaker(cfg)); dests[0].addr != "[fe80::1%eth0]:9000" {
+		t.Errorf("destination %q, want [fe80::1%%eth0]:9000", dests[0].addr)
+	}
+
+	conn, err := net.ListenPacket("udp6", "[::1]:0")
+	if err != nil {
+		t.Skip("no IPv6 loopback:", err)
+	}
+	defer conn.Close()
+	s := newUDPSender("::1", conn.LocalAddr().(*net.UDPAddr).Port, socketOptions{})
+	if err := s.Send(osc.NewMessage("/ping")); err != nil {
+		t.Fatalf("sending to [::1]: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 64)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := osc.NewMessage("/ping").MarshalBinary(); string(buf[:n]) != string(want) {
+		t.Errorf("received %q, want %q", buf[:n], want)
+	}
+}
+
+// deadUDPPort returns a loopback port nothing listens on.
+func deadUDPPort(t *testing.T) int {
+	t.Helper()
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	conn.Close()
+	return port
+}
+
+func TestUDPSenderReportsRefused(t *testing.T) {
+	s := newUDPSender("127.0.0.1", deadUDPPort(t), socketOptions{})
+	defer s.Close()
+
+	failed := 0
+	for i := 0; i < 5; i++ {
+		if s.Send(osc.NewMessage("/ping")) != nil {
+			failed++
+		}
+		time.Sleep(5 * time.Millisecond) // for the ICMP error to come back
+	}
+	// the first datagram has nothing to be refused before it
+	if failed != 4 {
+		t.Errorf("%d of 5 sends to a closed port failed, want every one after the first", failed)
+	}
+}
+
+func TestBreakerTripsOnClosedUDPPort(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.DestHost, cfg.DestPort = "127.0.0.1", deadUDPPort(t)
+	cfg.BreakerFailures = 3
+	cfg.BreakerProbeInterval = time.Hour
+	dests := newDestinations(cfg.AllDestinations(), false, newRetryPolicy(cfg), newBreaker(cfg))
+	defer closeDestinations(dests)
+
+	for i := 0; i < 10 && !dests[0].breaker.down; i++ {
+		sendTo(dests[0], osc.NewMessage("/ping"), "test")
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !dests[0].breaker.down {
+		t.Error("a UDP destination with nothing listening was never marked down")
+	}
+}
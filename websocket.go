@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// websocketGUID is appended to the client key to derive the accept key, see
+// RFC 6455 section 1.3
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// websocketWriteTimeout bounds a single frame write, a client that cannot
+// take a frame in this time is disconnected
+const websocketWriteTimeout = time.Second
+
+// wsMaxFrameSize is the largest frame accepted from a client. Clients have
+// nothing to send here, so anything bigger ends the connection.
+const wsMaxFrameSize = 64 * 1024
+
+// wsCloseTooBig is the close status for a frame over wsMaxFrameSize, see RFC
+// 6455 section 7.4.1
+const wsCloseTooBig = 1009
+
+// WebSocket opcodes used here
+const (
+	wsOpText  = 0x1
+	wsOpClose = 0x8
+	wsOpPing  = 0x9
+	wsOpPong  = 0xa
+)
+
+// wsUpdate is the JSON sent per update. Fields names the values the update
+// carries, the others are zero.
+type wsUpdate struct {
+	TrackerData
+	Fields []string `json:"fields"`
+}
+
+// serveWebSocket streams every processed update as a JSON text message. It
+// only implements the part of RFC 6455 a push-only server needs: the
+// handshake, unmasked text frames out, and answering pings and closes. Each
+// client reads from its own subscription, so a slow one loses updates
+// instead of holding up the pipeline.
+func serveWebSocket(tm *TrackerManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Sec-WebSocket-Key")
+		if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") || key == "" {
+			http.Error(w, "websocket upgrade required", http.StatusBadRequest)
+			return
+		}
+		if r.Header.Get("Sec-WebSocket-Version") != "13" {
+			w.Header().Set("Sec-WebSocket-Version", "13")
+			http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+			return
+		}
+		hijacker, ok := w.(http.Hijacker)
+		if !ok {
+			http.Error(w, "websocket not supported", http.StatusInternalServerError)
+			return
+		}
+		conn, rw, err := hijacker.Hijack()
+		if err != nil {
+			slog.Warn("WebSocket upgrade failed", "err", err)
+			return
+		}
+		defer conn.Close()
+
+		sum := sha1.Sum([]byte(key + websocketGUID))
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+			"Upgrade: websocket\r\n" +
+			"Connection: Upgrade\r\n" +
+			"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+		if err := rw.Flush(); err != nil {
+			return
+		}
+
+		updates, unsubscribe := tm.Subscribe()
+		defer unsubscribe()
+		ws := &wsConn{conn: conn}
+		closed := make(chan struct{})
+		go func() {
+			ws.readLoop(rw.Reader)
+			close(closed)
+		}()
+
+		slog.Debug("WebSocket client connected", "client", conn.RemoteAddr())
+		defer slog.Debug("WebSocket client disconnected", "client", conn.RemoteAddr())
+		for {
+			select {
+			case data, ok := <-updates:
+				if !ok {
+					ws.write(wsOpClose, nil) // shutting down
+					return
+				}
+				payload, err := json.Marshal(wsUpdate{TrackerData: data, Fields: updateFieldNames(data.Fields)})
+				if err != nil {
+					slog.Warn("Error encoding websocket update", "err", err)
+					continue
+				}
+				if ws.write(wsOpText, payload) != nil {
+					return
+				}
+			case <-closed:
+				return
+			}
+		}
+	}
+}
+
+// wsConn serializes frame writes, which come from both the update loop and
+// the read loop answering pings.
+type wsConn struct {
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func (c *wsConn) write(opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode} // FIN, no fragmentation
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xffff:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(websocketWriteTimeout))
+	_, err := (&net.Buffers{header, payload}).WriteTo(c.conn)
+	return err
+}
+
+// readLoop consumes client frames until the connection fails or the client
+// closes it. Data frames are ignored, pings are answered and a close is
+// echoed as the RFC asks. A frame over wsMaxFrameSize is answered with a close
+// and ends the connection.
+func (c *wsConn) readLoop(r *bufio.Reader) {
+	var header [2]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return
+		}
+		opcode := header[0] & 0x0f
+		masked := header[1]&0x80 != 0
+		n := uint64(header[1] & 0x7f)
+		switch n {
+		case 126:
+			var ext [2]byte
+			if _, err := io.ReadFull(r, ext[:]); err != nil {
+				return
+			}
+			n = uint64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			if _, err := io.ReadFull(r, ext[:]); err != nil {
+				return
+			}
+			n = binary.BigEndian.Uint64(ext[:])
+		}
+		if n > wsMaxFrameSize {
+			c.write(wsOpClose, binary.BigEndian.AppendUint16(nil, wsCloseTooBig))
+			return
+		}
+		var mask [4]byte
+		if masked {
+			if _, err := io.ReadFull(r, mask[:]); err != nil {
+				return
+			}
+		}
+
+		// control frames carry at most 125 bytes, anything else is discarded
+		if opcode < wsOpClose {
+			if _, err := io.CopyN(io.Discard, r, int64(n)); err != nil {
+				return
+			}
+			continue
+		}
+		if n > 125 {
+			return
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return
+		}
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+		switch opcode {
+		case wsOpClose:
+			c.write(wsOpClose, payload)
+			return
+		case wsOpPing:
+			if c.write(wsOpPong, payload) != nil {
+				return
+			}
+		}
+	}
+}
+
+// headerContains reports whether the comma separated header name has token,
+// ignoring case.
+func headerContains(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// wsReadLoop runs readLoop on one end of a loopback TCP connection and
+// returns the other end, and a channel closed once readLoop returned.
+func wsReadLoop(t *testing.T) (net.Conn, <-chan struct{}) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close(); client.Close() })
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+
+	done := make(chan struct{})
+	go func() {
+		(&wsConn{conn: server}).readLoop(bufio.NewReader(server))
+		close(done)
+	}()
+	return client, done
+}
+
+// clientFrame builds a masked client frame with the all zero mask, so the
+// payload goes out as it is.
+func clientFrame(opcode byte, payload []byte) []byte {
+	frame := []byte{0x80 | opcode, 0x80 | byte(len(payload)), 0, 0, 0, 0}
+	return append(frame, payload...)
+}
+
+func readFrame(t *testing.T, conn net.Conn) (opcode byte, payload []byte) {
+	t.Helper()
+	var header [2]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		t.Fatal(err)
+	}
+	payload = make([]byte, header[1]&0x7f)
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		t.Fatal(err)
+	}
+	return header[0] & 0x0f, payload
+}
+
+func TestWebSocketReadLoop(t *testing.T) {
+	conn, done := wsReadLoop(t)
+	if _, err := conn.Write(clientFrame(wsOpText, []byte("ignored"))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write(clientFrame(wsOpPing, []byte("hi"))); err != nil {
+		t.Fatal(err)
+	}
+	if opcode, payload := readFrame(t, conn); opcode != wsOpPong || string(payload) != "hi" {
+		t.Errorf("answered a ping with opcode %#x %q, want a pong with the same payload", opcode, payload)
+	}
+	if _, err := conn.Write(clientFrame(wsOpClose, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if opcode, _ := readFrame(t, conn); opcode != wsOpClose {
+		t.Errorf("answered a close with opcode %#x", opcode)
+	}
+	<-done
+}
+
+func TestWebSocketRejectsHugeFrames(t *testing.T) {
+	for _, n := range []uint64{wsMaxFrameSize + 1, 1 << 63, 1<<64 - 1} {
+		conn, done := wsReadLoop(t)
+		header := binary.BigEndian.AppendUint64([]byte{0x80 | wsOpText, 0x80 | 127}, n)
+		if _, err := conn.Write(header); err != nil {
+			t.Fatal(err)
+		}
+		opcode, payload := readFrame(t, conn)
+		if opcode != wsOpClose || len(payload) != 2 || binary.BigEndian.Uint16(payload) != wsCloseTooBig {
+			t.Errorf("answered a %d byte frame with opcode %#x %v, want a close with status 1009", n, opcode, payload)
+		}
+		<-done
+	}
+}